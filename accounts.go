@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+)
+
+type Account struct {
+	SystemId         string
+	Password         string
+	SystemType       string // empty value matches any system_type
+	InterfaceVersion byte   // zero value matches any interface_version
+}
+
+// Accounts is a registry of ESME credentials checked on bind requests.
+// In permissive mode every bind is accepted (credential mismatches are only logged)
+type Accounts struct {
+	Permissive bool
+	accounts   map[string]Account
+}
+
+func NewAccounts() *Accounts {
+	return &Accounts{true, make(map[string]Account)}
+}
+
+func (a *Accounts) Add(account Account) {
+	a.accounts[account.SystemId] = account
+}
+
+func (a *Accounts) Len() int {
+	return len(a.accounts)
+}
+
+// check bind request against registered accounts.
+// Returns *PduError with status which should be set in bind_resp
+func (a *Accounts) Authenticate(bind *Bind) error {
+	err := a.check(bind)
+	if err != nil && a.Permissive {
+		log.Printf("permissive mode, accepting bind from system_id[%s] despite: %v", bind.SystemId, err)
+		return nil
+	}
+	return err
+}
+
+func (a *Accounts) check(bind *Bind) error {
+	account, ok := a.accounts[bind.SystemId]
+	if !ok {
+		return pduError(STS_INV_SYS_ID, "unknown system_id [%s]", bind.SystemId)
+	}
+	if account.Password != bind.Password {
+		return pduError(STS_INV_PASSWD, "invalid password for system_id [%s]", bind.SystemId)
+	}
+	if account.SystemType != "" && account.SystemType != bind.SystemType {
+		return pduError(STS_INV_SYSTEM_TYPE, "invalid system_type [%s] for system_id [%s]", bind.SystemType, bind.SystemId)
+	}
+	if account.InterfaceVersion != 0 && account.InterfaceVersion != bind.InterfaceVersion {
+		return pduError(STS_BIND_FAILED, "unsupported interface_version 0x%02X for system_id [%s]", bind.InterfaceVersion, bind.SystemId)
+	}
+	return nil
+}
+
+// parse account definition in format "system_id:password[:system_type[:interface_version]]"
+func parseAccount(def string) (Account, error) {
+	parts := strings.Split(def, ":")
+	if len(parts) < 2 || len(parts) > 4 || parts[0] == "" {
+		return Account{}, fmt.Errorf("invalid account definition [%s]", def)
+	}
+	if len(parts[0]) > MAX_SYSTEM_ID_LEN-1 || len(parts[1]) > MAX_PASSWORD_LEN-1 {
+		return Account{}, fmt.Errorf("too long system_id or password in account definition [%s]", def)
+	}
+	account := Account{SystemId: parts[0], Password: parts[1]}
+	if len(parts) > 2 {
+		account.SystemType = parts[2]
+	}
+	if len(parts) > 3 && parts[3] != "" {
+		v, err := strconv.ParseUint(parts[3], 0, 8)
+		if err != nil {
+			return Account{}, fmt.Errorf("invalid interface_version in account definition [%s]", def)
+		}
+		account.InterfaceVersion = byte(v)
+	}
+	return account, nil
+}
+
+// read accounts file with one account definition per line. Empty lines and lines started with # are ignored
+func readAccounts(accounts *Accounts, r io.Reader) error {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		account, err := parseAccount(line)
+		if err != nil {
+			return err
+		}
+		accounts.Add(account)
+	}
+	return scanner.Err()
+}
+
+// load accounts from file and from comma separated list of definitions.
+// Registry is strict if at least one account was loaded and permissive otherwise
+func LoadAccounts(path, defs string) (*Accounts, error) {
+	accounts := NewAccounts()
+	if path != "" {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		if err := readAccounts(accounts, f); err != nil {
+			return nil, fmt.Errorf("cannot read accounts file %s: %v", path, err)
+		}
+	}
+	for _, def := range strings.Split(defs, ",") {
+		def = strings.TrimSpace(def)
+		if def == "" {
+			continue
+		}
+		account, err := parseAccount(def)
+		if err != nil {
+			return nil, err
+		}
+		accounts.Add(account)
+	}
+	accounts.Permissive = accounts.Len() == 0
+	return accounts, nil
+}
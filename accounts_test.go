@@ -0,0 +1,54 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseAccount(t *testing.T) {
+	account, err := parseAccount("client1:secret:VMA:0x34")
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	expected := Account{"client1", "secret", "VMA", 0x34}
+	if account != expected {
+		t.Errorf("expected %+v, got %+v", expected, account)
+	}
+
+	if _, err := parseAccount("client1"); err == nil {
+		t.Errorf("account without password should not be parsed")
+	}
+}
+
+func TestAuthenticate(t *testing.T) {
+	accounts := NewAccounts()
+	accounts.Permissive = false
+	err := readAccounts(accounts, strings.NewReader("# test accounts\nclient1:secret\n\nclient2:secret2:VMA\n"))
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	cases := []struct {
+		bind   Bind
+		status uint32
+	}{
+		{Bind{SystemId: "client1", Password: "secret", InterfaceVersion: 0x34}, STS_OK},
+		{Bind{SystemId: "client1", Password: "wrong"}, STS_INV_PASSWD},
+		{Bind{SystemId: "client3", Password: "secret"}, STS_INV_SYS_ID},
+		{Bind{SystemId: "client2", Password: "secret2", SystemType: "OTHER"}, STS_INV_SYSTEM_TYPE},
+	}
+	for _, c := range cases {
+		status := uint32(STS_OK)
+		if err := accounts.Authenticate(&c.bind); err != nil {
+			status = pduErrorStatus(err)
+		}
+		if status != c.status {
+			t.Errorf("bind %+v: expected status 0x%08X, got 0x%08X", c.bind, c.status, status)
+		}
+	}
+
+	accounts.Permissive = true
+	if err := accounts.Authenticate(&Bind{SystemId: "client1", Password: "wrong"}); err != nil {
+		t.Errorf("permissive mode should accept any bind, got %v", err)
+	}
+}
@@ -0,0 +1,423 @@
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+func writeJson(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		log.Printf("Cannot write json response due [%v]", err)
+	}
+}
+
+func writeJsonError(w http.ResponseWriter, status int, message string) {
+	writeJsonErrorCode(w, status, "", message)
+}
+
+// error response with machine readable code, e.g. {"error": "...", "code": "no_session"}
+func writeJsonErrorCode(w http.ResponseWriter, status int, code, message string) {
+	body := map[string]string{"error": message}
+	if code != "" {
+		body["code"] = code
+	}
+	writeJson(w, status, body)
+}
+
+// body of POST /api/v1/mo, optional fields have the same meaning as on the web page
+type moRequest struct {
+	Sender       string  `json:"sender"`
+	Recipient    string  `json:"recipient"`
+	Message      string  `json:"message"`
+	SystemId     string  `json:"system_id"`
+	Coding       string  `json:"coding"`
+	Segmentation string  `json:"segmentation"`
+	DataSm       bool    `json:"data_sm"`
+	Ref16        bool    `json:"ref16"`
+	Ref          *uint16 `json:"ref"` // next reference of the session if not set
+}
+
+// status of the response by MoError code
+var moErrorStatuses = map[string]int{
+	MO_ERR_NO_SESSION:      http.StatusNotFound,
+	MO_ERR_NOT_RECEIVER:    http.StatusConflict,
+	MO_ERR_INVALID_MESSAGE: http.StatusBadRequest,
+	MO_ERR_NETWORK:         http.StatusBadGateway,
+}
+
+// POST /api/v1/mo
+func moApiHandler(smsc *Smsc) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			writeJsonError(w, http.StatusMethodNotAllowed, "Method not allowed")
+			return
+		}
+		var req moRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeJsonErrorCode(w, http.StatusBadRequest, "invalid_request", "Cannot parse request body")
+			return
+		}
+		if req.SystemId == "" {
+			writeJsonErrorCode(w, http.StatusBadRequest, "invalid_request", "system_id is required")
+			return
+		}
+
+		opts := MoOptions{DataSm: req.DataSm, Coding: req.Coding, Segmentation: req.Segmentation, Ref16: req.Ref16}
+		if req.Ref != nil {
+			opts.ForceRef = true
+			opts.Ref = *req.Ref
+		}
+		seqNums, err := smsc.SendMoMessage(req.Sender, req.Recipient, req.Message, req.SystemId, opts)
+		if err != nil {
+			status, code := http.StatusInternalServerError, ""
+			if moErr, ok := err.(*MoError); ok {
+				status, code = moErrorStatuses[moErr.Code], moErr.Code
+			}
+			writeJsonErrorCode(w, status, code, err.Error())
+			return
+		}
+		writeJson(w, http.StatusOK, map[string]interface{}{
+			"system_id":        req.SystemId,
+			"parts":            len(seqNums),
+			"sequence_numbers": seqNums,
+		})
+	}
+}
+
+// GET /api/v1/sessions
+func sessionsListApiHandler(smsc *Smsc) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" {
+			writeJsonError(w, http.StatusMethodNotAllowed, "Method not allowed")
+			return
+		}
+		sessions := []map[string]interface{}{}
+		for _, session := range smsc.BoundSessions() {
+			sessions = append(sessions, map[string]interface{}{
+				"id":         session.Id,
+				"system_id":  session.SystemId,
+				"receive_mo": session.ReceiveMo,
+			})
+		}
+		writeJson(w, http.StatusOK, map[string]interface{}{
+			"system_ids": append([]string{}, smsc.BoundSystemIds()...),
+			"sessions":   sessions,
+		})
+	}
+}
+
+// POST /api/v1/sessions/{id}/unbind[?wait=true]
+// POST /api/v1/sessions/{id}/close
+func sessionsApiHandler(smsc *Smsc) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/api/v1/sessions/"), "/")
+		if len(parts) != 2 {
+			writeJsonError(w, http.StatusNotFound, "Not found")
+			return
+		}
+		if r.Method != "POST" {
+			writeJsonError(w, http.StatusMethodNotAllowed, "Method not allowed")
+			return
+		}
+		sessionId, err := strconv.Atoi(parts[0])
+		if err != nil {
+			writeJsonError(w, http.StatusBadRequest, "Invalid session id")
+			return
+		}
+		if _, ok := smsc.Sessions.Get(sessionId); !ok {
+			writeJsonError(w, http.StatusNotFound, "Session not found")
+			return
+		}
+
+		switch parts[1] {
+		case "unbind":
+			wait := r.URL.Query().Get("wait") == "true"
+			if err := smsc.UnbindSession(sessionId, wait); err != nil {
+				writeJsonError(w, http.StatusGatewayTimeout, err.Error())
+				return
+			}
+			result := "unbind_sent"
+			if wait {
+				result = "unbound"
+			}
+			writeJson(w, http.StatusOK, map[string]interface{}{"session_id": sessionId, "result": result})
+		case "close":
+			if err := smsc.CloseSession(sessionId); err != nil {
+				writeJsonError(w, http.StatusInternalServerError, err.Error())
+				return
+			}
+			writeJson(w, http.StatusOK, map[string]interface{}{"session_id": sessionId, "result": "closed"})
+		default:
+			writeJsonError(w, http.StatusNotFound, "Not found")
+		}
+	}
+}
+
+// filter of the stored messages built from query params system_id, source_addr and
+// destination_addr (patterns as in routes), from and to (RFC 3339 submit date range), text (substring)
+// and text_re (regular expression)
+func messageFilter(smsc *Smsc, q url.Values) (func(msg *Message) bool, error) {
+	var patterns [3]Pattern
+	for i, name := range []string{"system_id", "source_addr", "destination_addr"} {
+		p, err := parsePattern(q.Get(name))
+		if err != nil {
+			return nil, fmt.Errorf("Invalid %s: %v", name, err)
+		}
+		patterns[i] = p
+	}
+	var dates [2]time.Time
+	for i, name := range []string{"from", "to"} {
+		if v := q.Get(name); v != "" {
+			t, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				return nil, fmt.Errorf("Invalid %s date [%s], RFC 3339 expected", name, v)
+			}
+			dates[i] = t
+		}
+	}
+	text := q.Get("text")
+	textRe, err := regexp.Compile(q.Get("text_re"))
+	if err != nil {
+		return nil, fmt.Errorf("Invalid text_re: %v", err)
+	}
+	return func(msg *Message) bool {
+		return patterns[0].Match(msg.SystemId) &&
+			patterns[1].Match(msg.Sm.SourceAddr) &&
+			patterns[2].Match(msg.Sm.DestinationAddr) &&
+			(dates[0].IsZero() || !msg.SubmitDate.Before(dates[0])) &&
+			(dates[1].IsZero() || msg.SubmitDate.Before(dates[1])) &&
+			strings.Contains(smsc.smText(msg.Sm), text) &&
+			textRe.MatchString(smsc.smText(msg.Sm))
+	}, nil
+}
+
+// GET /api/v1/messages[?system_id=...&source_addr=...&destination_addr=...&from=...&to=...&text=...]
+// DELETE /api/v1/messages
+func messagesApiHandler(smsc *Smsc) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case "GET":
+			match, err := messageFilter(smsc, r.URL.Query())
+			if err != nil {
+				writeJsonErrorCode(w, http.StatusBadRequest, "invalid_request", err.Error())
+				return
+			}
+			messages := []map[string]interface{}{}
+			for _, msg := range smsc.Store.List(match) {
+				messages = append(messages, smsc.messageJson(&msg))
+			}
+			writeJson(w, http.StatusOK, map[string]interface{}{"messages": messages})
+		case "DELETE":
+			count := smsc.Store.Clear()
+			log.Printf("%d messages were removed from the store", count)
+			writeJson(w, http.StatusOK, map[string]interface{}{"removed": count})
+		default:
+			writeJsonError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		}
+	}
+}
+
+// default and max time to wait for a message
+const (
+	WAIT_TIMEOUT     = 10 * time.Second
+	MAX_WAIT_TIMEOUT = 5 * time.Minute
+)
+
+// GET /api/v1/messages/wait[?timeout=...&include_stored=true&<filter params>]
+// blocks until matching message is stored or timeout is elapsed. Only messages received
+// after the request are matched, unless include_stored is set
+func waitApiHandler(smsc *Smsc) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" {
+			writeJsonError(w, http.StatusMethodNotAllowed, "Method not allowed")
+			return
+		}
+		q := r.URL.Query()
+		match, err := messageFilter(smsc, q)
+		if err != nil {
+			writeJsonErrorCode(w, http.StatusBadRequest, "invalid_request", err.Error())
+			return
+		}
+		timeout := WAIT_TIMEOUT
+		if v := q.Get("timeout"); v != "" {
+			timeout, err = time.ParseDuration(v)
+			if err != nil || timeout < 0 || timeout > MAX_WAIT_TIMEOUT {
+				writeJsonErrorCode(w, http.StatusBadRequest, "invalid_request",
+					fmt.Sprintf("Invalid timeout [%s], duration up to %v expected", v, MAX_WAIT_TIMEOUT))
+				return
+			}
+		}
+
+		// waiting is also stopped when the client goes away
+		ctx, cancel := context.WithTimeout(r.Context(), timeout)
+		defer cancel()
+		msg, ok := smsc.Store.Wait(match, q.Get("include_stored") == "true", ctx.Done())
+		if !ok {
+			writeJsonErrorCode(w, http.StatusGatewayTimeout, "timeout", fmt.Sprintf("No matching message in %v", timeout))
+			return
+		}
+		writeJson(w, http.StatusOK, smsc.messageJson(&msg))
+	}
+}
+
+// GET /api/v1/messages/{message_id}[?destination_addr=...]
+// POST /api/v1/messages/{message_id}/dlr
+func messageApiHandler(smsc *Smsc) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/api/v1/messages/"), "/")
+		switch {
+		case len(parts) == 1 && r.Method == "GET":
+			msg, ok := findMessage(smsc, parts[0], r.URL.Query().Get("destination_addr"))
+			if !ok {
+				writeJsonErrorCode(w, http.StatusNotFound, "not_found", "Message not found")
+				return
+			}
+			writeJson(w, http.StatusOK, smsc.messageJson(&msg))
+		case len(parts) == 2 && parts[1] == "dlr" && r.Method == "POST":
+			manualDlrApi(smsc, parts[0], w, r)
+		case len(parts) <= 2:
+			writeJsonError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		default:
+			writeJsonError(w, http.StatusNotFound, "Not found")
+		}
+	}
+}
+
+// stored message by message_id. Recipients of the submit_multi share one message_id,
+// destination_addr chooses one of them (the first recipient by default)
+func findMessage(smsc *Smsc, msgId, destinationAddr string) (Message, bool) {
+	for _, msg := range smsc.Store.GetAll(msgId) {
+		if destinationAddr == "" || msg.Sm.DestinationAddr == destinationAddr {
+			return msg, true
+		}
+	}
+	return Message{}, false
+}
+
+// body of POST /api/v1/messages/{message_id}/dlr, dates are RFC 3339
+type dlrRequest struct {
+	Stat       string    `json:"stat"`
+	Err        int       `json:"err"`
+	SubmitDate time.Time `json:"submit_date"`
+	DoneDate   time.Time `json:"done_date"`
+}
+
+// status of the response by DlrError code
+var dlrErrorStatuses = map[string]int{
+	DLR_ERR_INVALID_STATE: http.StatusBadRequest,
+	DLR_ERR_NOT_FOUND:     http.StatusNotFound,
+	DLR_ERR_NO_SESSION:    http.StatusConflict,
+}
+
+func manualDlrApi(smsc *Smsc, key string, w http.ResponseWriter, r *http.Request) {
+	var req dlrRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJsonErrorCode(w, http.StatusBadRequest, "invalid_request", "Cannot parse request body")
+		return
+	}
+	state, ok := finalStateByStat(req.Stat)
+	if !ok || req.Err < 0 || req.Err > 999 {
+		writeJsonErrorCode(w, http.StatusBadRequest, "invalid_request", fmt.Sprintf("Invalid stat [%s] or err [%d]", req.Stat, req.Err))
+		return
+	}
+	msg, sent, err := smsc.DeliverManually(key, ManualDlr{state, req.Err, req.SubmitDate, req.DoneDate})
+	if err != nil {
+		status, code := http.StatusInternalServerError, ""
+		if dlrErr, ok := err.(*DlrError); ok {
+			status, code = dlrErrorStatuses[dlrErr.Code], dlrErr.Code
+		}
+		writeJsonErrorCode(w, status, code, err.Error())
+		return
+	}
+	body := smsc.messageJson(&msg)
+	body["receipt_sent"] = sent
+	writeJson(w, http.StatusOK, body)
+}
+
+func commandName(cmdId uint32) string {
+	switch cmdId {
+	case SUBMIT_SM:
+		return "submit_sm"
+	case SUBMIT_MULTI:
+		return "submit_multi"
+	case DATA_SM:
+		return "data_sm"
+	case DELIVER_SM:
+		return "deliver_sm"
+	default:
+		return fmt.Sprintf("0x%08X", cmdId)
+	}
+}
+
+func (smsc *Smsc) messageJson(msg *Message) map[string]interface{} {
+	sm := msg.Sm
+	body := map[string]interface{}{
+		"key":                 msg.Key,
+		"message_id":          msg.Id,
+		"command":             commandName(msg.CmdId),
+		"system_id":           msg.SystemId,
+		"service_type":        sm.ServiceType,
+		"source_addr":         sm.SourceAddr,
+		"destination_addr":    sm.DestinationAddr,
+		"esm_class":           sm.EsmClass,
+		"registered_delivery": sm.RegisteredDelivery,
+		"data_coding":         sm.DataCoding,
+		"schedule_delivery":   sm.ScheduleDeliveryTime,
+		"validity_period":     sm.ValidityPeriod,
+		"short_message":       hex.EncodeToString(sm.ShortMessage),
+		"text":                smsc.smText(msg.Sm),
+		"state":               stateName(msg.State),
+		"error_code":          msg.ErrorCode,
+		"submit_date":         msg.SubmitDate,
+	}
+	if !msg.FinalDate.IsZero() {
+		body["final_date"] = msg.FinalDate
+	}
+	return body
+}
+
+// GET /api/v1/concat
+func concatApiHandler(smsc *Smsc) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" {
+			writeJsonError(w, http.StatusMethodNotAllowed, "Method not allowed")
+			return
+		}
+		messages := []map[string]interface{}{}
+		for _, c := range smsc.Concat.List() {
+			messages = append(messages, concatJson(c))
+		}
+		writeJson(w, http.StatusOK, map[string]interface{}{"messages": messages})
+	}
+}
+
+func concatJson(c ConcatMessage) map[string]interface{} {
+	return map[string]interface{}{
+		"key":              c.Key,
+		"system_id":        c.SystemId,
+		"source_addr":      c.SourceAddr,
+		"destination_addr": c.DestinationAddr,
+		"ref":              c.Ref,
+		"total_segments":   c.Total,
+		"received":         len(c.Parts),
+		"complete":         c.Complete(),
+		"missing":          append([]int{}, c.Missing()...),
+		"duplicates":       append([]int{}, c.Duplicates...),
+		"invalid":          append([]int{}, c.Invalid...),
+		"message_ids":      c.MessageIds,
+		"text":             c.Text(),
+		"first_date":       c.FirstDate,
+		"last_date":        c.LastDate,
+	}
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMoApi(t *testing.T) {
+	smsc := NewSmsc(false)
+	client, server := net.Pipe()
+	defer client.Close()
+	go handleSmppConnection(smsc, server)
+	client.Write(bindTransceiverPDU("client1"))
+	readPduHeader(t, client) // bind_transceiver_resp
+
+	post := func(body string) (int, map[string]interface{}) {
+		rec := httptest.NewRecorder()
+		moApiHandler(smsc)(rec, httptest.NewRequest("POST", "/api/v1/mo", strings.NewReader(body)))
+		var resp map[string]interface{}
+		if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("invalid json response [%s]", rec.Body.String())
+		}
+		return rec.Code, resp
+	}
+
+	if status, resp := post(`{"system_id": "client2", "message": "Hi"}`); status != http.StatusNotFound || resp["code"] != MO_ERR_NO_SESSION {
+		t.Errorf("unexpected response %d %v", status, resp)
+	}
+	if status, resp := post(`{"system_id": "client1", "coding": "unknown"}`); status != http.StatusBadRequest || resp["code"] != MO_ERR_INVALID_MESSAGE {
+		t.Errorf("unexpected response %d %v", status, resp)
+	}
+	if status, resp := post(`{"message": "Hi"`); status != http.StatusBadRequest || resp["code"] != "invalid_request" {
+		t.Errorf("unexpected response %d %v", status, resp)
+	}
+
+	seqNums := make(chan uint32, 1)
+	go func() {
+		_, seqNum, _ := readPdu(client)
+		seqNums <- seqNum
+	}()
+	status, resp := post(`{"system_id": "client1", "sender": "7701", "recipient": "1001", "message": "Hi"}`)
+	if status != http.StatusOK || resp["parts"] != float64(1) {
+		t.Fatalf("unexpected response %d %v", status, resp)
+	}
+	if seqNum := <-seqNums; resp["sequence_numbers"].([]interface{})[0] != float64(seqNum) {
+		t.Errorf("expected sequence number %d, got %v", seqNum, resp["sequence_numbers"])
+	}
+}
+
+func TestMessagesApi(t *testing.T) {
+	smsc := NewSmsc(false)
+	now := time.Now()
+	smsc.Store.Add(Message{Id: "1", CmdId: SUBMIT_SM, SystemId: "client1", SubmitDate: now.Add(-time.Hour),
+		Sm: &SubmitSm{SourceAddr: "7701", DestinationAddr: "+77001234", ShortMessage: []byte("Your code is 1234")}})
+	smsc.Store.Add(Message{Id: "2", CmdId: SUBMIT_SM, SystemId: "client2", SubmitDate: now, State: STATE_ENROUTE,
+		Sm: &SubmitSm{SourceAddr: "7702", DestinationAddr: "+77005678", DataCoding: CODING_UCS2, ShortMessage: toUcs2Coding("Привет")}})
+
+	get := func(path string) (int, map[string]interface{}) {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", path, nil)
+		if strings.HasPrefix(path, "/api/v1/messages/") {
+			messageApiHandler(smsc)(rec, req)
+		} else {
+			messagesApiHandler(smsc)(rec, req)
+		}
+		var resp map[string]interface{}
+		json.Unmarshal(rec.Body.Bytes(), &resp)
+		return rec.Code, resp
+	}
+
+	cases := []struct {
+		query string
+		ids   []string
+	}{
+		{"", []string{"1", "2"}},
+		{"?system_id=client2", []string{"2"}},
+		{"?destination_addr=%2B7700*&text=code", []string{"1"}},
+		{"?text=Привет", []string{"2"}},
+		{"?source_addr=7703", nil},
+		{"?from=" + url.QueryEscape(now.Add(-time.Minute).Format(time.RFC3339)), []string{"2"}},
+	}
+	for _, c := range cases {
+		_, resp := get("/api/v1/messages" + c.query)
+		var ids []string
+		for _, m := range resp["messages"].([]interface{}) {
+			ids = append(ids, m.(map[string]interface{})["message_id"].(string))
+		}
+		if !reflect.DeepEqual(ids, c.ids) {
+			t.Errorf("query [%s]: expected %v, got %v", c.query, c.ids, ids)
+		}
+	}
+	if status, _ := get("/api/v1/messages?from=yesterday"); status != http.StatusBadRequest {
+		t.Errorf("invalid date should be rejected, got %d", status)
+	}
+
+	if status, resp := get("/api/v1/messages/2"); status != http.StatusOK || resp["text"] != "Привет" || resp["state"] != "ENROUTE" {
+		t.Errorf("unexpected response %d %v", status, resp)
+	}
+	if status, _ := get("/api/v1/messages/3"); status != http.StatusNotFound {
+		t.Errorf("expected not found, got %d", status)
+	}
+
+	// recipients of the submit_multi share message_id
+	for _, dest := range []string{"1001", "1002"} {
+		smsc.Store.Add(Message{Id: "5", CmdId: SUBMIT_MULTI, SystemId: "client1", SubmitDate: now,
+			Sm: &SubmitSm{SourceAddr: "7701", DestinationAddr: dest}})
+	}
+	if status, resp := get("/api/v1/messages/5?destination_addr=1002"); status != http.StatusOK || resp["destination_addr"] != "1002" {
+		t.Errorf("unexpected response %d %v", status, resp)
+	}
+	if status, resp := get("/api/v1/messages/5"); status != http.StatusOK || resp["destination_addr"] != "1001" {
+		t.Errorf("unexpected response %d %v", status, resp)
+	}
+	if status, _ := get("/api/v1/messages/5?destination_addr=1003"); status != http.StatusNotFound {
+		t.Errorf("expected not found, got %d", status)
+	}
+
+	rec := httptest.NewRecorder()
+	messagesApiHandler(smsc)(rec, httptest.NewRequest("DELETE", "/api/v1/messages", nil))
+	if _, resp := get("/api/v1/messages"); rec.Code != http.StatusOK || len(resp["messages"].([]interface{})) != 0 {
+		t.Errorf("store should be cleared")
+	}
+}
+
+func TestManualDlrApi(t *testing.T) {
+	smsc := NewSmsc(false)
+	smsc.ManualDlr = true
+	smsc.Store.Add(Message{Id: "1", SystemId: "client1", State: STATE_ENROUTE, SubmitDate: time.Now(),
+		Sm: &SubmitSm{SourceAddr: "7701", DestinationAddr: "1001"}})
+	smsc.Store.Add(Message{Id: "2", SystemId: "client1", State: STATE_ENROUTE, SubmitDate: time.Now(),
+		Sm: &SubmitSm{SourceAddr: "7701", DestinationAddr: "1001", RegisteredDelivery: REG_DLV_RECEIPT}})
+
+	post := func(id, body string) (int, map[string]interface{}) {
+		rec := httptest.NewRecorder()
+		messageApiHandler(smsc)(rec, httptest.NewRequest("POST", "/api/v1/messages/"+id+"/dlr", strings.NewReader(body)))
+		var resp map[string]interface{}
+		json.Unmarshal(rec.Body.Bytes(), &resp)
+		return rec.Code, resp
+	}
+
+	if status, resp := post("1", `{"stat": "ENROUTE"}`); status != http.StatusBadRequest || resp["code"] != "invalid_request" {
+		t.Errorf("unexpected response %d %v", status, resp)
+	}
+	if status, resp := post("3", `{"stat": "DELIVRD"}`); status != http.StatusNotFound || resp["code"] != "not_found" {
+		t.Errorf("unexpected response %d %v", status, resp)
+	}
+	if status, resp := post("2", `{"stat": "DELIVRD"}`); status != http.StatusConflict || resp["code"] != "no_session" {
+		t.Errorf("unexpected response %d %v", status, resp)
+	}
+	status, resp := post("1", `{"stat": "ACCEPTD"}`)
+	if status != http.StatusOK || resp["state"] != "ACCEPTED" || resp["receipt_sent"] != false || resp["final_date"] == nil {
+		t.Errorf("unexpected response %d %v", status, resp)
+	}
+}
+
+func TestWaitApi(t *testing.T) {
+	smsc := NewSmsc(false)
+	wait := func(query string) (int, map[string]interface{}) {
+		rec := httptest.NewRecorder()
+		waitApiHandler(smsc)(rec, httptest.NewRequest("GET", "/api/v1/messages/wait"+query, nil))
+		var resp map[string]interface{}
+		json.Unmarshal(rec.Body.Bytes(), &resp)
+		return rec.Code, resp
+	}
+
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		smsc.Store.Add(Message{Id: "1", SystemId: "client1", Sm: &SubmitSm{DestinationAddr: "1001", ShortMessage: []byte("Code 1234")}})
+		smsc.Store.Add(Message{Id: "2", SystemId: "client1", Sm: &SubmitSm{DestinationAddr: "1002", ShortMessage: []byte("Code 5678")}})
+	}()
+	status, resp := wait("?timeout=2s&destination_addr=1002&text_re=^Code%20[0-9]{4}$")
+	if status != http.StatusOK || resp["message_id"] != "2" {
+		t.Errorf("unexpected response %d %v", status, resp)
+	}
+
+	// already stored messages are matched only on request
+	if status, _ := wait("?timeout=50ms&text=1234"); status != http.StatusGatewayTimeout {
+		t.Errorf("already stored message should not be returned, got %d", status)
+	}
+	if status, resp := wait("?timeout=2s&text=1234&include_stored=true"); status != http.StatusOK || resp["message_id"] != "1" {
+		t.Errorf("unexpected response %d %v", status, resp)
+	}
+	if status, resp := wait("?timeout=50ms&system_id=client2"); status != http.StatusGatewayTimeout || resp["code"] != "timeout" {
+		t.Errorf("unexpected response %d %v", status, resp)
+	}
+	if status, _ := wait("?timeout=1h"); status != http.StatusBadRequest {
+		t.Errorf("too long timeout should be rejected, got %d", status)
+	}
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"unicode/utf16"
+)
+
+// data_coding values which could be selected for MO messages
+var MO_CODINGS = map[string]byte{
+	"gsm7":     CODING_DEFAULT,
+	"ia5":      CODING_IA5,
+	"octet":    CODING_OCTET,
+	"latin1":   CODING_LATIN1,
+	"binary":   CODING_BINARY,
+	"cyrillic": CODING_CYRILLIC,
+	"ucs2":     CODING_UCS2,
+	"flash":    CODING_FLASH,
+}
+
+// default alphabet: 0x00, message waiting groups 0xC0-0xDF and 0xF0 family without 8-bit data bit
+func isGsm7Coding(coding byte) bool {
+	return coding == CODING_DEFAULT || (coding >= 0xC0 && coding <= 0xDF) || coding&0xF4 == 0xF0
+}
+
+// 8-bit binary data: 0x02, 0x04 and 0xF0 family with 8-bit data bit
+func isBinaryCoding(coding byte) bool {
+	return coding == CODING_OCTET || coding == CODING_BINARY || coding&0xF4 == 0xF4
+}
+
+// UCS2: 0x08 and message waiting group 0xE0-0xEF
+func isUcs2Coding(coding byte) bool {
+	return coding == CODING_UCS2 || coding&0xF0 == 0xE0
+}
+
+// ISO-8859-5 characters 0xA0-0xFF
+var cyrillicHigh = func() []rune {
+	table := make([]rune, 0x60)
+	for i := range table {
+		b := 0xA0 + i
+		switch {
+		case b == 0xA0 || b == 0xAD:
+			table[i] = rune(b) // no-break space and soft hyphen
+		case b == 0xF0:
+			table[i] = '№'
+		case b == 0xFD:
+			table[i] = '§'
+		default:
+			table[i] = rune(0x0400 + b - 0xA0)
+		}
+	}
+	return table
+}()
+
+// decode short message of the inbound PDU to string. Binary data is returned as hex string
+func decodeShortMessage(data []byte, coding byte) string {
+	switch {
+	case isGsm7Coding(coding):
+		return decodeGsm7(data)
+	case isUcs2Coding(coding):
+		u := make([]uint16, len(data)/2)
+		for i := range u {
+			u[i] = binary.BigEndian.Uint16(data[i*2:])
+		}
+		return string(utf16.Decode(u))
+	case isBinaryCoding(coding):
+		return hex.EncodeToString(data)
+	}
+
+	var sb strings.Builder
+	for _, b := range data {
+		switch {
+		case coding == CODING_IA5:
+			sb.WriteByte(b & 0x7F)
+		case coding == CODING_CYRILLIC && b >= 0xA0:
+			sb.WriteRune(cyrillicHigh[b-0xA0])
+		default: // Latin-1 and unknown codings
+			sb.WriteRune(rune(b))
+		}
+	}
+	return sb.String()
+}
+
+// encode text with the data_coding. Text of binary codings should be hex encoded
+func encodeText(text string, coding byte) ([]byte, error) {
+	switch {
+	case isGsm7Coding(coding):
+		septets, ok := encodeGsm7(text)
+		if !ok {
+			return nil, fmt.Errorf("Text has characters missing in GSM7 alphabet")
+		}
+		return septets, nil
+	case isUcs2Coding(coding):
+		return toUcs2Coding(text), nil
+	case isBinaryCoding(coding):
+		data, err := hex.DecodeString(strings.Replace(text, " ", "", -1))
+		if err != nil {
+			return nil, fmt.Errorf("Binary message should be hex encoded")
+		}
+		return data, nil
+	}
+
+	data := make([]byte, 0, len(text))
+	for _, r := range text {
+		b, ok := encodeRune(r, coding)
+		if !ok {
+			return nil, fmt.Errorf("Character [%c] cannot be encoded with data_coding 0x%02X", r, coding)
+		}
+		data = append(data, b)
+	}
+	return data, nil
+}
+
+func encodeRune(r rune, coding byte) (byte, bool) {
+	switch coding {
+	case CODING_IA5:
+		return byte(r), r < 0x80
+	case CODING_CYRILLIC:
+		if r < 0xA0 {
+			return byte(r), true
+		}
+		for i, c := range cyrillicHigh {
+			if c == r {
+				return byte(0xA0 + i), true
+			}
+		}
+		return 0, false
+	default: // Latin-1
+		return byte(r), r < 0x100
+	}
+}
+
+// decoded text of the short message (or message_payload), UDH of the concatenated message segment is skipped
+func (smsc *Smsc) smText(sm *SubmitSm) string {
+	data, fillBits := sm.ShortMessage, 0
+	if seg, ok := segmentOf(sm); ok {
+		data, fillBits = seg.Data, seg.FillBits
+	} else if payload, ok := findTlv(sm.Tlvs, TLV_MESSAGE_PAYLOAD); ok && len(data) == 0 {
+		data = payload.Value
+	}
+	return decodeShortMessage(smsc.gsm7Septets(data, sm.DataCoding, fillBits), sm.DataCoding)
+}
+
+// GSM7 data unpacked to one septet per octet if short messages are packed, other data as is
+func (smsc *Smsc) gsm7Septets(data []byte, coding byte, fillBits int) []byte {
+	if smsc.Gsm7Packed && isGsm7Coding(coding) {
+		return unpackGsm7(data, fillBits)
+	}
+	return data
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestEncodeAndDecodeText(t *testing.T) {
+	cases := []struct {
+		coding byte
+		text   string
+		data   []byte
+	}{
+		{CODING_IA5, "Hi!", []byte("Hi!")},
+		{CODING_LATIN1, "Grüße", []byte{'G', 'r', 0xFC, 0xDF, 'e'}},
+		{CODING_CYRILLIC, "Привет №1", []byte{0xBF, 0xE0, 0xD8, 0xD2, 0xD5, 0xE2, ' ', 0xF0, '1'}},
+		{CODING_BINARY, "0102ff", []byte{0x01, 0x02, 0xFF}},
+		{CODING_OCTET, "0a0b", []byte{0x0A, 0x0B}},
+		{CODING_FLASH, "@£", []byte{0x00, 0x01}},
+		{CODING_UCS2, "Hi", []byte{0x00, 'H', 0x00, 'i'}},
+	}
+	for _, c := range cases {
+		data, err := encodeText(c.text, c.coding)
+		if err != nil {
+			t.Errorf("coding 0x%02X: unexpected error %v", c.coding, err)
+			continue
+		}
+		if string(data) != string(c.data) {
+			t.Errorf("coding 0x%02X: expected %v, got %v", c.coding, c.data, data)
+		}
+		if text := decodeShortMessage(data, c.coding); text != c.text {
+			t.Errorf("coding 0x%02X: expected [%s], got [%s]", c.coding, c.text, text)
+		}
+	}
+
+	for _, c := range []struct {
+		coding byte
+		text   string
+	}{
+		{CODING_IA5, "Grüße"},
+		{CODING_LATIN1, "Привет"},
+		{CODING_CYRILLIC, "Grüße"},
+		{CODING_BINARY, "xyz"},
+	} {
+		if _, err := encodeText(c.text, c.coding); err == nil {
+			t.Errorf("text [%s] should not be encoded with data_coding 0x%02X", c.text, c.coding)
+		}
+	}
+}
+
+func TestMoCoding(t *testing.T) {
+	data, coding, err := encodeMoText("Grüße", "latin1")
+	if err != nil || coding != CODING_LATIN1 || len(data) != 5 {
+		t.Errorf("unexpected latin1 data %v with coding 0x%02X, error %v", data, coding, err)
+	}
+	if _, _, err := encodeMoText("text", "unknown"); err == nil {
+		t.Errorf("unknown coding should be rejected")
+	}
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+// UDH information elements of concatenated messages
+const (
+	IE_CONCAT_8BIT  = 0x00
+	IE_CONCAT_16BIT = 0x08
+)
+
+// result of adding segment to the concatenated message
+const (
+	SEGMENT_ADDED = iota
+	SEGMENT_DUPLICATE
+	SEGMENT_INVALID // sequence number or total number of segments does not match the message
+)
+
+// max number of concatenated messages kept in the store, oldest messages are evicted first
+const MAX_CONCAT_MESSAGES = 10000
+
+// Segment is a part of the concatenated message
+type Segment struct {
+	Ref   int
+	Total int
+	Seq   int
+	Data  []byte // short message without UDH
+	// padding bits before the first septet of packed GSM7 message, which aligns septets after UDH
+	FillBits int
+}
+
+// find concatenation info either in UDH (esm_class with UDHI set) or in SAR TLVs
+func segmentOf(sm *SubmitSm) (Segment, bool) {
+	data := sm.ShortMessage
+	if sm.EsmClass&0x40 != 0 && len(data) > 0 {
+		udhLen := int(data[0])
+		if len(data) < 1+udhLen {
+			return Segment{}, false
+		}
+		udh := data[1 : 1+udhLen]
+		data = data[1+udhLen:]
+		fillBits := gsm7FillBits(1 + udhLen)
+		for i := 0; i+1 < len(udh); i += 2 + int(udh[i+1]) {
+			iei, iel := udh[i], int(udh[i+1])
+			if i+2+iel > len(udh) {
+				break
+			}
+			v := udh[i+2 : i+2+iel]
+			switch {
+			case iei == IE_CONCAT_8BIT && iel == 3:
+				return Segment{int(v[0]), int(v[1]), int(v[2]), data, fillBits}, true
+			case iei == IE_CONCAT_16BIT && iel == 4:
+				return Segment{int(binary.BigEndian.Uint16(v)), int(v[2]), int(v[3]), data, fillBits}, true
+			}
+		}
+	}
+
+	ref, refOk := findTlv(sm.Tlvs, TLV_SAR_MSG_REF_NUM)
+	total, totalOk := findTlv(sm.Tlvs, TLV_SAR_TOTAL_SEGMENTS)
+	seq, seqOk := findTlv(sm.Tlvs, TLV_SAR_SEGMENT_SEQNUM)
+	if refOk && totalOk && seqOk {
+		return Segment{int(binary.BigEndian.Uint16(ref.Value)), int(total.Value[0]), int(seq.Value[0]), data, 0}, true
+	}
+	return Segment{}, false
+}
+
+// ConcatMessage is a logical message reassembled from the received segments
+type ConcatMessage struct {
+	Key             string
+	SystemId        string
+	SourceAddr      string
+	DestinationAddr string
+	Ref             int
+	Total           int
+	DataCoding      byte
+	Parts           map[int]Segment // segments by sequence number
+	MessageIds      []string        // message ids of the segments in order of arrival
+	Duplicates      []int           // sequence numbers of segments received more than once
+	Invalid         []int           // sequence numbers out of 1..Total range
+	FirstDate       time.Time
+	LastDate        time.Time
+	identity        string // system_id, addresses and reference number shared by the segments
+}
+
+func (c ConcatMessage) Complete() bool {
+	return len(c.Missing()) == 0
+}
+
+// sequence numbers of segments which are not received yet
+func (c ConcatMessage) Missing() []int {
+	var missing []int
+	for seq := 1; seq <= c.Total; seq++ {
+		if _, ok := c.Parts[seq]; !ok {
+			missing = append(missing, seq)
+		}
+	}
+	return missing
+}
+
+// decoded text of the received segments
+func (c ConcatMessage) Text() string {
+	var sb strings.Builder
+	for seq := 1; seq <= c.Total; seq++ {
+		seg, ok := c.Parts[seq]
+		if !ok {
+			continue
+		}
+		sb.WriteString(decodeShortMessage(seg.Data, c.DataCoding))
+	}
+	return sb.String()
+}
+
+func (c ConcatMessage) copy() ConcatMessage {
+	parts := make(map[int]Segment, len(c.Parts))
+	for seq, seg := range c.Parts {
+		parts[seq] = seg
+	}
+	c.Parts = parts
+	c.MessageIds = append([]string(nil), c.MessageIds...)
+	c.Duplicates = append([]int(nil), c.Duplicates...)
+	c.Invalid = append([]int(nil), c.Invalid...)
+	return c
+}
+
+// ConcatStore reassembles concatenated messages. All methods are safe for concurrent use
+type ConcatStore struct {
+	mu       sync.Mutex
+	messages map[string]*ConcatMessage
+	keys     keyRing           // in order of arrival
+	open     map[string]string // latest message key by segment identity
+	lastId   int
+}
+
+func NewConcatStore() *ConcatStore {
+	return &ConcatStore{
+		messages: make(map[string]*ConcatMessage),
+		keys:     keyRing{max: MAX_CONCAT_MESSAGES},
+		open:     make(map[string]string),
+	}
+}
+
+// add segment to the concatenated message and return the updated message.
+// Segments are grouped by system_id, addresses and reference number. Segment of the complete message
+// is counted as duplicate if it has the same data, otherwise it starts a new message with reused reference
+func (store *ConcatStore) Add(systemId, msgId string, sm *SubmitSm, seg Segment) (ConcatMessage, int) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	identity := fmt.Sprintf("%s|%s|%s|%d", systemId, sm.SourceAddr, sm.DestinationAddr, seg.Ref)
+	msg := store.messages[store.open[identity]]
+	if msg != nil && msg.Complete() && !bytes.Equal(msg.Parts[seg.Seq].Data, seg.Data) {
+		msg = nil
+	}
+	if msg == nil {
+		if store.keys.Full() {
+			oldest := store.keys.At(0)
+			if identity := store.messages[oldest].identity; store.open[identity] == oldest {
+				delete(store.open, identity)
+			}
+			delete(store.messages, oldest)
+		}
+		store.lastId++
+		msg = &ConcatMessage{
+			Key:             fmt.Sprintf("c%d", store.lastId),
+			SystemId:        systemId,
+			SourceAddr:      sm.SourceAddr,
+			DestinationAddr: sm.DestinationAddr,
+			Ref:             seg.Ref,
+			Total:           seg.Total,
+			DataCoding:      sm.DataCoding,
+			Parts:           make(map[int]Segment),
+			FirstDate:       time.Now(),
+			identity:        identity,
+		}
+		store.messages[msg.Key] = msg
+		store.keys.Push(msg.Key)
+		store.open[identity] = msg.Key
+	}
+
+	msg.MessageIds = append(msg.MessageIds, msgId)
+	msg.LastDate = time.Now()
+	result := SEGMENT_ADDED
+	if _, dup := msg.Parts[seg.Seq]; dup {
+		result = SEGMENT_DUPLICATE
+		msg.Duplicates = append(msg.Duplicates, seg.Seq)
+	} else if seg.Seq < 1 || seg.Seq > msg.Total || seg.Total != msg.Total {
+		result = SEGMENT_INVALID
+		msg.Invalid = append(msg.Invalid, seg.Seq)
+	} else {
+		msg.Parts[seg.Seq] = seg
+	}
+	return msg.copy(), result
+}
+
+// concatenated messages in order of arrival
+func (store *ConcatStore) List() []ConcatMessage {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	list := make([]ConcatMessage, 0, store.keys.Len())
+	for i := 0; i < store.keys.Len(); i++ {
+		list = append(list, store.messages[store.keys.At(i)].copy())
+	}
+	return list
+}
+
+// register submitted message as a segment if it has concatenation info
+func (smsc *Smsc) addSegment(systemId, msgId string, sm *SubmitSm) {
+	seg, ok := segmentOf(sm)
+	if !ok {
+		return
+	}
+	// store keeps GSM7 segments unpacked, so their text does not depend on the packing
+	seg.Data, seg.FillBits = smsc.gsm7Septets(seg.Data, sm.DataCoding, seg.FillBits), 0
+	msg, result := smsc.Concat.Add(systemId, msgId, sm, seg)
+	switch {
+	case result == SEGMENT_DUPLICATE:
+		log.Printf("duplicate segment %d of concatenated message [%s] from system_id[%s]", seg.Seq, msg.Key, systemId)
+	case result == SEGMENT_INVALID:
+		log.Printf("invalid segment %d/%d of concatenated message [%s] from system_id[%s]", seg.Seq, seg.Total, msg.Key, systemId)
+	case msg.Complete():
+		log.Printf("concatenated message [%s] from system_id[%s] was reassembled from %d segments", msg.Key, systemId, msg.Total)
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestSegmentOf(t *testing.T) {
+	cases := []struct {
+		sm  SubmitSm
+		seg Segment
+	}{
+		{SubmitSm{EsmClass: 0x40, ShortMessage: []byte{0x05, 0x00, 0x03, 0x2A, 0x02, 0x01, 'H', 'i'}}, Segment{0x2A, 2, 1, []byte("Hi"), 1}},
+		{SubmitSm{EsmClass: 0x40, ShortMessage: []byte{0x06, 0x08, 0x04, 0x01, 0x2A, 0x03, 0x02, 'H', 'i'}}, Segment{0x012A, 3, 2, []byte("Hi"), 0}},
+		{SubmitSm{ShortMessage: []byte("Hi"), Tlvs: []Tlv{
+			{TLV_SAR_MSG_REF_NUM, 2, []byte{0x00, 0x07}},
+			{TLV_SAR_TOTAL_SEGMENTS, 1, []byte{0x02}},
+			{TLV_SAR_SEGMENT_SEQNUM, 1, []byte{0x02}},
+		}}, Segment{7, 2, 2, []byte("Hi"), 0}},
+	}
+	for _, c := range cases {
+		seg, ok := segmentOf(&c.sm)
+		if !ok || !reflect.DeepEqual(seg, c.seg) {
+			t.Errorf("expected %+v, got %+v", c.seg, seg)
+		}
+	}
+
+	if _, ok := segmentOf(&SubmitSm{ShortMessage: []byte{0x05, 0x00, 0x03, 0x2A, 0x02, 0x01}}); ok {
+		t.Errorf("message without UDHI should not be a segment")
+	}
+}
+
+func TestConcatReassembly(t *testing.T) {
+	store := NewConcatStore()
+	sm := &SubmitSm{SourceAddr: "7701", DestinationAddr: "1001"}
+	add := func(msgId string, seq int, data string) (ConcatMessage, int) {
+		return store.Add("client1", msgId, sm, Segment{0x2A, 3, seq, []byte(data), 0})
+	}
+
+	add("1", 3, "!")
+	msg, _ := add("2", 1, "Hello, ")
+	if msg.Complete() || !reflect.DeepEqual(msg.Missing(), []int{2}) {
+		t.Errorf("expected missing segment 2, got %v", msg.Missing())
+	}
+	if _, result := add("3", 1, "Hello, "); result != SEGMENT_DUPLICATE {
+		t.Errorf("expected duplicate segment, got %d", result)
+	}
+	if _, result := add("4", 5, "?"); result != SEGMENT_INVALID {
+		t.Errorf("expected invalid segment, got %d", result)
+	}
+	msg, _ = add("5", 2, "world")
+	if !msg.Complete() || msg.Text() != "Hello, world!" {
+		t.Errorf("expected complete message, got %+v", msg)
+	}
+	if !reflect.DeepEqual(msg.Duplicates, []int{1}) || !reflect.DeepEqual(msg.MessageIds, []string{"1", "2", "3", "4", "5"}) {
+		t.Errorf("unexpected message %+v", msg)
+	}
+
+	// reference is reused by the next message
+	msg, _ = add("6", 1, "Next")
+	if msg.Key == store.List()[0].Key || len(store.List()) != 2 {
+		t.Errorf("segment with new data should start a new message")
+	}
+}
+
+func TestConcatStoreEviction(t *testing.T) {
+	store := NewConcatStore()
+	store.keys.max = 2
+	for ref := 1; ref <= 3; ref++ {
+		store.Add("client1", fmt.Sprint(ref), &SubmitSm{SourceAddr: "7701", DestinationAddr: "1001"}, Segment{ref, 2, 1, []byte("Hi"), 0})
+	}
+	list := store.List()
+	if len(list) != 2 || list[0].Ref != 2 || list[1].Ref != 3 {
+		t.Errorf("oldest message should be evicted, got %+v", list)
+	}
+	if len(store.messages) != 2 || len(store.open) != 2 {
+		t.Errorf("evicted message should be removed from the store, got %d messages and %d open", len(store.messages), len(store.open))
+	}
+}
+
+func TestPackedGsm7Reassembly(t *testing.T) {
+	smsc := NewSmsc(false)
+	smsc.Gsm7Packed = true
+	for i, part := range []string{"Hello, ", "world!"} {
+		septets, _ := encodeGsm7(part)
+		udh := []byte{0x05, 0x00, 0x03, 0x2A, 0x02, byte(i + 1)}
+		data := append(udh, packGsm7(septets, gsm7FillBits(len(udh)))...)
+		sm := &SubmitSm{SourceAddr: "7701", DestinationAddr: "1001", EsmClass: 0x40, ShortMessage: data}
+		if text := smsc.smText(sm); text != part {
+			t.Errorf("unexpected segment text [%s]", text)
+		}
+		smsc.addSegment("client1", fmt.Sprint(i+1), sm)
+	}
+	if list := smsc.Concat.List(); len(list) != 1 || list[0].Text() != "Hello, world!" {
+		t.Errorf("packed segments should be reassembled, got %+v", list)
+	}
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"encoding/hex"
+	"html/template"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const SESSIONS_PAGE_TPL = `
+{{ template "header" }}
+<div id="container" class="wide">
+  <p id="title">Bound sessions</p>
+  {{ if .Message }}
+  <p id="message">{{ .Message }}</p>
+  {{ end }}
+  {{ if .ErrorMessage }}
+  <p class="error">{{ .ErrorMessage }}</p>
+  {{ end }}
+  {{ if not .Sessions }}
+  <p><sub>No bound sessions</sub></p>
+  {{ else }}
+  <table>
+    <tr>
+      <th>#</th><th>System ID</th><th>Bind type</th><th>Remote address</th><th>Bound since</th>
+      <th>PDUs in / out</th><th>Submits</th><th>Delivers</th><th></th>
+    </tr>
+    {{ range $session := .Sessions }}
+    {{ $stats := $session.Stats.Snapshot }}
+    <tr>
+      <td>{{ $session.Id }}</td>
+      <td>{{ $session.SystemId }}</td>
+      <td>{{ $session.BindType }}</td>
+      <td>{{ $session.RemoteAddr }}</td>
+      <td>{{ $session.BoundSince.Format "2006-01-02 15:04:05" }}</td>
+      <td>{{ $stats.PdusIn }} / {{ $stats.PdusOut }}</td>
+      <td>{{ $stats.Submits }}</td>
+      <td>{{ $stats.Delivers }}</td>
+      <td>
+        <form class="session" action="/sessions" method="POST">
+          <input type="hidden" name="session_id" value="{{ $session.Id }}">
+          <button type="submit" name="action" value="unbind">Unbind</button>
+          <button type="submit" name="action" value="unbind_wait">Unbind and wait</button>
+          <button type="submit" name="action" value="close">Close connection</button>
+        </form>
+      </td>
+    </tr>
+    {{ end }}
+  </table>
+  {{ end }}
+</div>
+{{ template "footer" }}
+`
+
+const LOG_PAGE_TPL = `
+{{ template "header" 3 }}
+<div id="container" class="wide">
+  <p id="title">Message log</p>
+  <p>
+    <a href="/log">all</a> |
+    <a href="/log?kind=submit">submits</a> |
+    <a href="/log?kind=dlr">receipts</a> |
+    <a href="/log?kind=mo">MO messages</a>
+  </p>
+  {{ if not .Entries }}
+  <p><sub>No messages</sub></p>
+  {{ else }}
+  <table>
+    <tr>
+      <th>Time</th><th>Kind</th><th>PDU</th><th>System ID</th><th>Source</th><th>Destination</th><th>Message ID</th><th>Text</th>
+    </tr>
+    {{ range $entry := .Entries }}
+    <tr>
+      <td>{{ $entry.Date.Format "15:04:05.000" }}</td>
+      <td>{{ $entry.Kind }}</td>
+      <td><a href="/log/{{ $entry.Id }}">{{ $entry.Command }}</a></td>
+      <td>{{ $entry.SystemId }}</td>
+      <td>{{ $entry.SourceAddr }}</td>
+      <td>{{ $entry.DestinationAddr }}</td>
+      <td>{{ if $entry.MessageId }}<a href="/messages/{{ $entry.MessageId }}">{{ $entry.MessageId }}</a>{{ end }}</td>
+      <td>{{ $entry.Text }}</td>
+    </tr>
+    {{ end }}
+  </table>
+  {{ end }}
+</div>
+{{ template "footer" }}
+`
+
+const LOG_ENTRY_PAGE_TPL = `
+{{ template "header" }}
+<div id="container" class="wide">
+  <p id="title">{{ .Command }} #{{ .Id }}</p>
+  <table>
+    <tr><th>Time</th><td>{{ .Date.Format "2006-01-02 15:04:05.000" }}</td></tr>
+    <tr><th>Kind</th><td>{{ .Kind }}</td></tr>
+    <tr><th>System ID</th><td>{{ .SystemId }}</td></tr>
+    <tr><th>Source</th><td>{{ .SourceAddr }}</td></tr>
+    <tr><th>Destination</th><td>{{ .DestinationAddr }}</td></tr>
+    {{ if .MessageId }}
+    <tr><th>Message ID</th><td><a href="/messages/{{ .MessageId }}">{{ .MessageId }}</a></td></tr>
+    {{ end }}
+    <tr><th>Text</th><td>{{ .Text }}</td></tr>
+  </table>
+  <pre class="hex">{{ hexdump .Pdu }}</pre>
+</div>
+{{ template "footer" }}
+`
+
+const MESSAGE_PAGE_TPL = `
+{{ template "header" }}
+<div id="container" class="wide">
+  <p id="title">Message {{ .Id }}</p>
+  {{ if not .Found }}
+  <p><sub>Message is not in the store</sub></p>
+  {{ else }}
+  {{ $msg := .Message }}
+  <table>
+    <tr><th>System ID</th><td>{{ $msg.SystemId }}</td></tr>
+    <tr><th>Service type</th><td>{{ $msg.Sm.ServiceType }}</td></tr>
+    <tr><th>Source</th><td>{{ $msg.Sm.SourceAddr }}</td></tr>
+    <tr><th>Destination</th><td>{{ $msg.Sm.DestinationAddr }}</td></tr>
+    <tr><th>esm_class</th><td>{{ printf "0x%02X" $msg.Sm.EsmClass }}</td></tr>
+    <tr><th>registered_delivery</th><td>{{ printf "0x%02X" $msg.Sm.RegisteredDelivery }}</td></tr>
+    <tr><th>data_coding</th><td>{{ printf "0x%02X" $msg.Sm.DataCoding }}</td></tr>
+    <tr><th>State</th><td>{{ state $msg.State }}{{ if $msg.ErrorCode }}, err {{ $msg.ErrorCode }}{{ end }}</td></tr>
+    <tr><th>Submit date</th><td>{{ $msg.SubmitDate.Format "2006-01-02 15:04:05.000" }}</td></tr>
+    {{ if not $msg.FinalDate.IsZero }}
+    <tr><th>Final date</th><td>{{ $msg.FinalDate.Format "2006-01-02 15:04:05.000" }}</td></tr>
+    {{ end }}
+    <tr><th>Text</th><td>{{ .Text }}</td></tr>
+  </table>
+  {{ end }}
+  {{ range $entry := .Entries }}
+  <p>{{ $entry.Date.Format "15:04:05.000" }} <a href="/log/{{ $entry.Id }}">{{ $entry.Command }}</a> {{ $entry.Text }}</p>
+  <pre class="hex">{{ hexdump $entry.Pdu }}</pre>
+  {{ end }}
+</div>
+{{ template "footer" }}
+`
+
+// max number of entries shown on the message log page
+const LOG_PAGE_ENTRIES = 200
+
+type SessionsTplVars struct {
+	Sessions     []*Session
+	Message      string
+	ErrorMessage string
+}
+
+type LogTplVars struct {
+	Entries []TrafficEntry
+}
+
+type MessageTplVars struct {
+	Id      string
+	Found   bool
+	Message Message
+	Text    string
+	Entries []TrafficEntry // PDUs of the message in order of arrival
+}
+
+// parse page template together with the layout
+func pageTemplate(name, page string) *template.Template {
+	funcs := template.FuncMap{"hexdump": hex.Dump, "state": stateName}
+	tpl, err := template.New(name).Funcs(funcs).Parse(WEB_LAYOUT_TPL + page)
+	if err != nil {
+		log.Fatalf("Cannot parse template of the %s page: %v", name, err)
+	}
+	return tpl
+}
+
+// latest submits, receipts and MO messages, optionally filtered by kind param
+func logWebHandler(smsc *Smsc) func(http.ResponseWriter, *http.Request) {
+	tpl := pageTemplate("log", LOG_PAGE_TPL)
+	return func(w http.ResponseWriter, r *http.Request) {
+		kind := r.URL.Query().Get("kind")
+		entries := smsc.Traffic.Latest(LOG_PAGE_ENTRIES, func(entry *TrafficEntry) bool {
+			return kind == "" || entry.Kind == kind
+		})
+		tpl.Execute(w, LogTplVars{entries})
+	}
+}
+
+// single PDU of the message log with its hex dump
+func logEntryWebHandler(smsc *Smsc) func(http.ResponseWriter, *http.Request) {
+	tpl := pageTemplate("log entry", LOG_ENTRY_PAGE_TPL)
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.Atoi(strings.TrimPrefix(r.URL.Path, "/log/"))
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+		entry, ok := smsc.Traffic.Get(id)
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		tpl.Execute(w, entry)
+	}
+}
+
+// stored message with PDUs of its submission and receipts
+func messageWebHandler(smsc *Smsc) func(http.ResponseWriter, *http.Request) {
+	tpl := pageTemplate("message", MESSAGE_PAGE_TPL)
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := strings.TrimPrefix(r.URL.Path, "/messages/")
+		vars := MessageTplVars{Id: id}
+		vars.Message, vars.Found = smsc.Store.Get(id)
+		if vars.Found {
+			vars.Text = smsc.smText(vars.Message.Sm)
+		}
+		entries := smsc.Traffic.Latest(MAX_TRAFFIC_ENTRIES, func(entry *TrafficEntry) bool {
+			return entry.MessageId == id
+		})
+		for i := len(entries) - 1; i >= 0; i-- {
+			vars.Entries = append(vars.Entries, entries[i])
+		}
+		if !vars.Found && len(vars.Entries) == 0 {
+			http.NotFound(w, r)
+			return
+		}
+		tpl.Execute(w, vars)
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDashboardPages(t *testing.T) {
+	smsc := NewSmsc(false)
+	smsc.Sessions.Add(&Session{Id: 1, SystemId: "client1", BindType: "TRANSCEIVER", RemoteAddr: "127.0.0.1:40000", BoundSince: time.Now()})
+	sm := &SubmitSm{SourceAddr: "7701", DestinationAddr: "1001", ShortMessage: []byte("<b>Hello</b>")}
+	smsc.Store.Add(Message{Id: "42", CmdId: SUBMIT_SM, SystemId: "client1", Sm: sm, State: STATE_ENROUTE, SubmitDate: time.Now()})
+	smsc.logSubmit("client1", "42", sm, nil, []byte{0x00, 0x00, 0x00, 0x10, 0x00, 0x00, 0x00, 0x04, 0, 0, 0, 0, 0, 0, 0, 1})
+	smsc.Traffic.Add(TrafficEntry{Kind: TRAFFIC_MO, SystemId: "client1", Text: "MO text"})
+
+	cases := []struct {
+		path     string
+		handler  func(http.ResponseWriter, *http.Request)
+		expected []string
+	}{
+		{"/", webHandler(smsc), []string{"Send MO message", `href="/log"`, "Pending messages", `href="/messages/42"`}},
+		{"/?error=%3Cb%3EHello%3C%2Fb%3E&sender=%22%3E%3Cb%3EHello", webHandler(smsc), []string{"&lt;b&gt;Hello&lt;/b&gt;", "&#34;&gt;&lt;b&gt;Hello"}},
+		{"/sessions?message=%3Cb%3EHello%3C%2Fb%3E", sessionsWebHandler(smsc), []string{"&lt;b&gt;Hello&lt;/b&gt;"}},
+		{"/sessions", sessionsWebHandler(smsc), []string{"client1", "TRANSCEIVER", "127.0.0.1:40000"}},
+		{"/log", logWebHandler(smsc), []string{"submit_sm", "&lt;b&gt;Hello&lt;/b&gt;", "MO text", `href="/messages/42"`}},
+		{"/log?kind=mo", logWebHandler(smsc), []string{"MO text"}},
+		{"/log/1", logEntryWebHandler(smsc), []string{"submit_sm #1", "00 00 00 10 00 00 00 04"}},
+		{"/messages/42", messageWebHandler(smsc), []string{"ENROUTE", "&lt;b&gt;Hello&lt;/b&gt;", "00 00 00 10 00 00 00 04"}},
+	}
+	for _, c := range cases {
+		rec := httptest.NewRecorder()
+		c.handler(rec, httptest.NewRequest("GET", c.path, nil))
+		body := rec.Body.String()
+		if rec.Code != http.StatusOK {
+			t.Errorf("%s: expected status 200, got %d", c.path, rec.Code)
+		}
+		for _, expected := range c.expected {
+			if !strings.Contains(body, expected) {
+				t.Errorf("%s: page should contain [%s]", c.path, expected)
+			}
+		}
+		if strings.Contains(body, "<b>Hello") {
+			t.Errorf("%s: text should be escaped", c.path)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	logWebHandler(smsc)(rec, httptest.NewRequest("GET", "/log?kind=dlr", nil))
+	if strings.Contains(rec.Body.String(), "MO text") {
+		t.Errorf("log should be filtered by kind")
+	}
+	rec = httptest.NewRecorder()
+	messageWebHandler(smsc)(rec, httptest.NewRequest("GET", "/messages/43", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("expected not found for unknown message, got %d", rec.Code)
+	}
+}
+
+func TestSessionStatsOfConnection(t *testing.T) {
+	smsc := NewSmsc(false)
+	client, server := net.Pipe()
+	defer client.Close()
+	client.SetDeadline(time.Now().Add(time.Second))
+	go handleSmppConnection(smsc, server)
+	client.Write(bindTransceiverPDU("client1"))
+	readPduHeader(t, client) // bind_transceiver_resp
+	client.Write(headerPDU(ENQUIRE_LINK_RESP, STS_OK, 2))
+	client.Write(headerPDU(ENQUIRE_LINK, STS_OK, 3))
+	readPduHeader(t, client) // enquire_link_resp
+
+	sessions := smsc.Sessions.FindBySystemId("client1")
+	if len(sessions) != 1 {
+		t.Fatalf("expected one session, got %d", len(sessions))
+	}
+	if s := sessions[0].Stats.Snapshot(); s.PdusIn != 3 || s.PdusOut != 2 {
+		t.Errorf("nothing should be sent or counted for enquire_link_resp, got %+v", s)
+	}
+}
+
+func TestSessionStats(t *testing.T) {
+	stats := &SessionStats{}
+	stats.countIn(SUBMIT_SM)
+	stats.countIn(ENQUIRE_LINK)
+	stats.countOut(headerPDU(SUBMIT_SM_RESP, STS_OK, 1))
+	stats.countOut(deliverSmPDU("7701", "1001", []byte("Hi"), CODING_DEFAULT, 2, 0, nil))
+	if s := stats.Snapshot(); s != (SessionStats{PdusIn: 2, PdusOut: 2, Submits: 1, Delivers: 1}) {
+		t.Errorf("unexpected stats %+v", s)
+	}
+}
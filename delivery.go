@@ -0,0 +1,405 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"time"
+)
+
+// default delay between message submission and its delivery
+const DELIVERY_DELAY = 2000 * time.Millisecond
+
+// registered_delivery bits
+const (
+	REG_DLV_RECEIPT_MASK     = 0x03
+	REG_DLV_RECEIPT          = 0x01 // receipt on success or failure
+	REG_DLV_RECEIPT_FAILURE  = 0x02 // receipt on failure only
+	REG_DLV_RECEIPT_SUCCESS  = 0x03 // receipt on success only (reserved in SMPP 3.4, defined in SMPP 5.0)
+	REG_DLV_SME_DELIVERY_ACK = 0x04
+	REG_DLV_SME_MANUAL_ACK   = 0x08
+	REG_DLV_INTERMEDIATE     = 0x10
+)
+
+// esm_class message types of the PDUs sent to the ESME
+const (
+	ESM_SMSC_RECEIPT     = 0x04
+	ESM_SME_DELIVERY_ACK = 0x08
+	ESM_SME_MANUAL_ACK   = 0x10
+	ESM_INTERMEDIATE     = 0x20
+)
+
+// schedule delivery of the stored message. Delivery receipt (if requested) will be sent to the conn.
+// Delay is counted from the schedule_delivery_time, message expires if it cannot be delivered
+// before its validity_period. If intermediate notifications are requested, ENROUTE and ACCEPTD
+// notifications are sent after 1/3 and 2/3 of the delay. In manual DLR mode message is left pending
+func (smsc *Smsc) scheduleDelivery(key string, delay time.Duration, conn net.Conn) {
+	smsc.Store.Update(key, func(m *Message) {
+		if m.timer != nil {
+			m.timer.Stop()
+		}
+		m.schedule++
+		schedule := m.schedule
+		m.conn = conn
+		if smsc.ManualDlr {
+			m.timer = nil
+			return
+		}
+		now := time.Now()
+		if schedule := m.ScheduleDate(); schedule.After(now) {
+			delay += schedule.Sub(now)
+		}
+		if expiry := m.ExpiryDate(); !expiry.IsZero() && now.Add(delay).After(expiry) {
+			delay = expiry.Sub(now)
+		}
+		if m.Sm.RegisteredDelivery&REG_DLV_INTERMEDIATE == 0 {
+			m.timer = time.AfterFunc(delay, func() {
+				smsc.deliverMessage(key, schedule, conn)
+			})
+			return
+		}
+		step := delay / 3
+		m.timer = time.AfterFunc(step, func() {
+			smsc.notifyIntermediate(key, schedule, STATE_ENROUTE, conn)
+			smsc.scheduleNext(key, schedule, step, func() {
+				smsc.notifyIntermediate(key, schedule, STATE_ACCEPTED, conn)
+				smsc.scheduleNext(key, schedule, delay-2*step, func() {
+					smsc.deliverMessage(key, schedule, conn)
+				})
+			})
+		})
+	})
+}
+
+// schedule next delivery step of the pending message, unless it was rescheduled since
+func (smsc *Smsc) scheduleNext(key string, schedule uint64, delay time.Duration, step func()) {
+	smsc.Store.Update(key, func(m *Message) {
+		if !m.IsFinal() && m.schedule == schedule {
+			m.timer = time.AfterFunc(delay, step)
+		}
+	})
+}
+
+// move pending message to the intermediate state and send intermediate notification
+func (smsc *Smsc) notifyIntermediate(key string, schedule uint64, state byte, conn net.Conn) {
+	var msg Message
+	pending := false
+	smsc.Store.Update(key, func(m *Message) {
+		if m.IsFinal() || m.schedule != schedule {
+			return // message was cancelled or rescheduled
+		}
+		m.State = state
+		msg = *m
+		pending = true
+	})
+	if pending {
+		smsc.sendToEsme(conn, receiptPDU(msg, ESM_INTERMEDIATE, time.Now()), "intermediate notification", msg)
+	}
+}
+
+// move message to the state chosen by the DLR policy of its route (or to EXPIRED state) and send
+// delivery receipt and SME acknowledgements requested by registered_delivery
+func (smsc *Smsc) deliverMessage(key string, schedule uint64, conn net.Conn) {
+	var msg Message
+	delivered := false
+	smsc.Store.Update(key, func(m *Message) {
+		if m.IsFinal() || m.schedule != schedule {
+			return // message was cancelled or rescheduled
+		}
+		if expiry := m.ExpiryDate(); !expiry.IsZero() && !time.Now().Before(expiry) {
+			m.State = STATE_EXPIRED
+			m.ErrorCode = 0
+		} else {
+			outcome := smsc.dlrPolicy(smsc.Routes.Find(m.SystemId, m.Sm)).Outcome()
+			m.State = outcome.State
+			m.ErrorCode = outcome.Err
+		}
+		m.FinalDate = time.Now()
+		m.timer = nil
+		msg = *m
+		delivered = true
+	})
+	if delivered {
+		smsc.sendReceipts(msg, conn)
+	}
+}
+
+// send delivery receipt and SME acknowledgements requested by registered_delivery of the delivered message.
+// Returns false if delivery receipt was not requested for the final state of the message
+func (smsc *Smsc) sendReceipts(msg Message, conn net.Conn) bool {
+	regDelivery := msg.Sm.RegisteredDelivery
+	receipt := receiptRequested(regDelivery, msg.State)
+	if receipt {
+		smsc.sendToEsme(conn, receiptPDU(msg, ESM_SMSC_RECEIPT, msg.FinalDate), "delivery receipt", msg)
+	}
+	if msg.State != STATE_DELIVERED {
+		return receipt
+	}
+	var userMsgRef []Tlv
+	if ref, ok := findTlv(msg.Sm.Tlvs, TLV_USER_MSG_REF); ok {
+		userMsgRef = []Tlv{ref}
+	}
+	if regDelivery&REG_DLV_SME_DELIVERY_ACK != 0 {
+		ack := smeAckPDU(msg.Sm.DestinationAddr, msg.Sm.SourceAddr, msg.Id, userMsgRef, ESM_SME_DELIVERY_ACK)
+		smsc.sendToEsme(conn, ack, "delivery acknowledgement", msg)
+	}
+	if regDelivery&REG_DLV_SME_MANUAL_ACK != 0 {
+		ack := smeAckPDU(msg.Sm.DestinationAddr, msg.Sm.SourceAddr, msg.Id, userMsgRef, ESM_SME_MANUAL_ACK)
+		smsc.sendToEsme(conn, ack, "manual acknowledgement", msg)
+	}
+	return receipt
+}
+
+// ManualDlr is the final state of the message chosen by hand. Zero dates
+// are replaced with the submit date of the message and the current time
+type ManualDlr struct {
+	State      byte
+	Err        int
+	SubmitDate time.Time
+	DoneDate   time.Time
+}
+
+// DlrError is returned when manual delivery receipt cannot be sent, Code is one of DLR_ERR_* values
+type DlrError struct {
+	Code    string
+	Message string
+}
+
+func (e *DlrError) Error() string {
+	return e.Message
+}
+
+const (
+	DLR_ERR_INVALID_STATE = "invalid_request"
+	DLR_ERR_NOT_FOUND     = "not_found"
+	DLR_ERR_NO_SESSION    = "no_session"
+)
+
+// move stored message to the final state and send its delivery receipt (if requested by registered_delivery).
+// Pending delivery is cancelled, receipt of already delivered message is overridden. Receipt is sent to the
+// connection the message was submitted on or, if it is closed, to another RECEIVER or TRANSCEIVER session
+// of the same system_id, session is required only if registered_delivery asks for a receipt or an acknowledgement.
+// Returns updated message and whether delivery receipt was sent, *DlrError on failure
+func (smsc *Smsc) DeliverManually(key string, dlr ManualDlr) (Message, bool, error) {
+	if _, ok := receiptStats[dlr.State]; !ok || dlr.State == STATE_ENROUTE {
+		return Message{}, false, &DlrError{DLR_ERR_INVALID_STATE, fmt.Sprintf("Invalid final state [%d]", dlr.State)}
+	}
+	msg, ok := smsc.Store.Get(key)
+	if !ok {
+		return Message{}, false, &DlrError{DLR_ERR_NOT_FOUND, fmt.Sprintf("Message [%s] not found", key)}
+	}
+	conn := smsc.receiptConn(msg)
+	if conn == nil && notificationRequested(msg.Sm.RegisteredDelivery, dlr.State) {
+		return msg, false, &DlrError{DLR_ERR_NO_SESSION, fmt.Sprintf("No session of system_id[%s] could receive delivery receipt", msg.SystemId)}
+	}
+
+	if dlr.DoneDate.IsZero() {
+		dlr.DoneDate = time.Now()
+	}
+	smsc.Store.Update(key, func(m *Message) {
+		if m.timer != nil {
+			m.timer.Stop()
+			m.timer = nil
+		}
+		m.State = dlr.State
+		m.ErrorCode = dlr.Err
+		m.FinalDate = dlr.DoneDate
+		msg = *m
+	})
+	receipt := msg
+	if !dlr.SubmitDate.IsZero() {
+		receipt.SubmitDate = dlr.SubmitDate
+	}
+	log.Printf("message [%s] of system_id[%s] was moved to %s state by hand", msg.Id, msg.SystemId, stateName(msg.State))
+	return msg, smsc.sendReceipts(receipt, conn), nil
+}
+
+// connection to send receipts of the message to
+func (smsc *Smsc) receiptConn(msg Message) net.Conn {
+	var fallback net.Conn
+	for _, session := range smsc.Sessions.FindBySystemId(msg.SystemId) {
+		if session.conn == msg.conn {
+			return msg.conn
+		}
+		if session.ReceiveMo && fallback == nil {
+			fallback = session.conn
+		}
+	}
+	return fallback
+}
+
+// check SMSC delivery receipt bits of the registered_delivery against final state of the message
+func receiptRequested(regDelivery, state byte) bool {
+	switch regDelivery & REG_DLV_RECEIPT_MASK {
+	case REG_DLV_RECEIPT:
+		return true
+	case REG_DLV_RECEIPT_FAILURE:
+		return state != STATE_DELIVERED
+	case REG_DLV_RECEIPT_SUCCESS:
+		return state == STATE_DELIVERED
+	default:
+		return false
+	}
+}
+
+// whether sendReceipts writes anything for the message in the final state
+func notificationRequested(regDelivery, state byte) bool {
+	smeAck := regDelivery&(REG_DLV_SME_DELIVERY_ACK|REG_DLV_SME_MANUAL_ACK) != 0
+	return receiptRequested(regDelivery, state) || (state == STATE_DELIVERED && smeAck)
+}
+
+// delivery receipt or intermediate notification in the current state of the message.
+// Messages submitted with data_sm get their receipts via data_sm
+func receiptPDU(msg Message, esmClass byte, doneDate time.Time) []byte {
+	if msg.CmdId == DATA_SM {
+		return dataSmReceiptPDU(msg.Sm.DestinationAddr, msg.Sm.SourceAddr, msg.Id, msg.SubmitDate, doneDate, msg.State, msg.ErrorCode, esmClass)
+	}
+	return deliveryReceiptPDU(msg.Sm.DestinationAddr, msg.Sm.SourceAddr, msg.Id, msg.SubmitDate, doneDate, msg.State, msg.ErrorCode, esmClass)
+}
+
+func (smsc *Smsc) sendToEsme(conn net.Conn, pdu []byte, name string, msg Message) {
+	if _, err := conn.Write(pdu); err != nil {
+		log.Printf("error sending %s to system_id[%s] due %v.", name, msg.SystemId, err)
+		return
+	}
+	log.Printf("%s for message [%s] was send to system_id[%s]", name, msg.Id, msg.SystemId)
+	smsc.Traffic.Add(TrafficEntry{
+		Kind:            TRAFFIC_DLR,
+		SystemId:        msg.SystemId,
+		SourceAddr:      msg.Sm.DestinationAddr,
+		DestinationAddr: msg.Sm.SourceAddr,
+		MessageId:       msg.Id,
+		Text:            fmt.Sprintf("%s stat:%s err:%03d", name, receiptStat(msg.State), msg.ErrorCode),
+		Pdu:             pdu,
+	})
+}
+
+func cancelPending(m *Message) {
+	if m.timer != nil {
+		m.timer.Stop()
+		m.timer = nil
+	}
+	m.State = STATE_DELETED
+	m.FinalDate = time.Now()
+}
+
+// cancel pending messages of the system_id either by message_id or by
+// service_type, source_addr and destination_addr. Returns *PduError on failure
+func (smsc *Smsc) cancelMessages(systemId string, c *CancelSm) error {
+	if c.MessageId != "" {
+		var err error
+		cancelled := 0
+		found := smsc.Store.UpdateMatching(func(m *Message) bool {
+			return m.Id == c.MessageId && m.SystemId == systemId
+		}, func(m *Message) {
+			if m.Sm.SourceAddr != c.SourceAddr {
+				err = pduError(STS_CANCEL_FAIL, "source_addr [%s] does not match message [%s]", c.SourceAddr, c.MessageId)
+			} else if c.DestinationAddr != "" && m.Sm.DestinationAddr != c.DestinationAddr {
+				err = pduError(STS_CANCEL_FAIL, "destination_addr [%s] does not match message [%s]", c.DestinationAddr, c.MessageId)
+			} else if m.IsFinal() {
+				err = pduError(STS_CANCEL_FAIL, "message [%s] is already in final state %s", c.MessageId, stateName(m.State))
+			} else {
+				cancelPending(m)
+				cancelled++
+			}
+		})
+		if found == 0 {
+			return pduError(STS_INV_MSG_ID, "unknown message [%s]", c.MessageId)
+		}
+		if cancelled == 0 {
+			return err
+		}
+		return nil
+	}
+
+	if c.DestinationAddr == "" {
+		return pduError(STS_INV_DST_ADR, "destination_addr is required when message_id is empty")
+	}
+	count := smsc.Store.UpdateMatching(func(m *Message) bool {
+		return m.SystemId == systemId && !m.IsFinal() &&
+			m.Sm.SourceAddr == c.SourceAddr &&
+			m.Sm.DestinationAddr == c.DestinationAddr &&
+			(c.ServiceType == "" || m.Sm.ServiceType == c.ServiceType)
+	}, cancelPending)
+	if count == 0 {
+		return pduError(STS_CANCEL_FAIL, "no pending messages from [%s] to [%s]", c.SourceAddr, c.DestinationAddr)
+	}
+	log.Printf("%d messages from [%s] to [%s] were cancelled by system_id[%s]", count, c.SourceAddr, c.DestinationAddr, systemId)
+	return nil
+}
+
+// replace short message and delivery params of the pending message and reschedule its delivery.
+// Relative times are counted from the submit date of the message. Returns *PduError on failure
+func (smsc *Smsc) replaceMessage(systemId string, rs *ReplaceSm) error {
+	var err error
+	var replacedKeys []string
+	found := smsc.Store.UpdateMatching(func(m *Message) bool {
+		return m.Id == rs.MessageId && m.SystemId == systemId
+	}, func(m *Message) {
+		if m.Sm.SourceAddr != rs.SourceAddr {
+			err = pduError(STS_REPLACE_FAIL, "source_addr [%s] does not match message [%s]", rs.SourceAddr, rs.MessageId)
+			return
+		}
+		if m.IsFinal() {
+			err = pduError(STS_REPLACE_FAIL, "message [%s] is already in final state %s", rs.MessageId, stateName(m.State))
+			return
+		}
+		// copy, since previous version could be still referenced by message copies
+		sm := *m.Sm
+		// NULL schedule_delivery_time and validity_period keep the original values
+		if rs.ScheduleDeliveryTime != "" {
+			sm.ScheduleDeliveryTime = rs.ScheduleDeliveryTime
+		}
+		if rs.ValidityPeriod != "" {
+			sm.ValidityPeriod = rs.ValidityPeriod
+		}
+		if timesErr := validateSmTimes(sm.ScheduleDeliveryTime, sm.ValidityPeriod, m.SubmitDate, time.Now()); timesErr != nil {
+			err = timesErr
+			return
+		}
+		sm.RegisteredDelivery = rs.RegisteredDelivery
+		sm.SmDefaultMsgId = rs.SmDefaultMsgId
+		sm.ShortMessage = rs.ShortMessage
+		m.Sm = &sm
+		replacedKeys = append(replacedKeys, m.Key)
+	})
+	if found == 0 {
+		return pduError(STS_INV_MSG_ID, "unknown message [%s]", rs.MessageId)
+	}
+	if len(replacedKeys) == 0 {
+		return err
+	}
+	for _, key := range replacedKeys {
+		smsc.reschedule(key)
+	}
+	return nil
+}
+
+// find pending message which should be replaced by the submit_sm with replace_if_present_flag set
+// and reschedule its delivery. Returns message_id of the replaced message or empty string
+func (smsc *Smsc) replaceIfPresent(systemId string, sm *SubmitSm) string {
+	msgId, key := "", ""
+	smsc.Store.UpdateMatching(func(m *Message) bool {
+		return msgId == "" && m.SystemId == systemId && !m.IsFinal() &&
+			m.Sm.SourceAddr == sm.SourceAddr &&
+			m.Sm.DestinationAddr == sm.DestinationAddr &&
+			m.Sm.ServiceType == sm.ServiceType
+	}, func(m *Message) {
+		m.Sm = sm
+		m.SubmitDate = time.Now() // relative times of the new submit_sm are counted from now
+		msgId, key = m.Id, m.Key
+	})
+	if key != "" {
+		smsc.reschedule(key)
+	}
+	return msgId
+}
+
+// schedule delivery of the replaced message again with its new delivery params
+func (smsc *Smsc) reschedule(key string) {
+	msg, ok := smsc.Store.Get(key)
+	if !ok {
+		return
+	}
+	smsc.scheduleDelivery(key, smsc.dlrPolicy(smsc.Routes.Find(msg.SystemId, msg.Sm)).Delay.Sample(), msg.conn)
+}
@@ -0,0 +1,312 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestCancelAndReplaceMessage(t *testing.T) {
+	smsc := NewSmsc(false)
+	sm := &SubmitSm{SourceAddr: "7701", DestinationAddr: "1001", ValidityPeriod: "000001000000000R", ShortMessage: []byte("Test")}
+	smsc.Store.Add(Message{Id: "1", SystemId: "client1", Sm: sm, State: STATE_ENROUTE, SubmitDate: time.Now()})
+	smsc.scheduleDelivery("1", time.Hour, nil)
+
+	replace := &ReplaceSm{MessageId: "1", SourceAddr: "7701", ShortMessage: []byte("Replaced")}
+	if err := smsc.replaceMessage("client1", replace); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if msg, _ := smsc.Store.Get("1"); string(msg.Sm.ShortMessage) != "Replaced" || msg.Sm.ValidityPeriod != "000001000000000R" {
+		t.Errorf("short message should be replaced and NULL validity_period should keep the original, got %+v", msg.Sm)
+	}
+
+	if err := smsc.cancelMessages("client2", &CancelSm{MessageId: "1", SourceAddr: "7701"}); pduErrorStatus(err) != STS_INV_MSG_ID {
+		t.Errorf("message of another system_id should not be cancelled, got %v", err)
+	}
+	if err := smsc.cancelMessages("client1", &CancelSm{SourceAddr: "7701", DestinationAddr: "1001"}); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if msg, _ := smsc.Store.Get("1"); msg.State != STATE_DELETED || msg.timer != nil {
+		t.Errorf("message was not cancelled")
+	}
+
+	if err := smsc.cancelMessages("client1", &CancelSm{MessageId: "1", SourceAddr: "7701"}); pduErrorStatus(err) != STS_CANCEL_FAIL {
+		t.Errorf("expected cancel failure for final message, got %v", err)
+	}
+	if err := smsc.replaceMessage("client1", replace); pduErrorStatus(err) != STS_REPLACE_FAIL {
+		t.Errorf("expected replace failure for final message, got %v", err)
+	}
+}
+
+func TestRegisteredDelivery(t *testing.T) {
+	smsc := NewSmsc(false)
+	client, server := net.Pipe()
+	defer client.Close()
+	userMsgRef := Tlv{TLV_USER_MSG_REF, 2, []byte{0x00, 0x07}}
+	sm := &SubmitSm{SourceAddr: "7701", DestinationAddr: "1001", RegisteredDelivery: 0x15, Tlvs: []Tlv{userMsgRef}}
+	smsc.Store.Add(Message{Id: "1", SystemId: "client1", Sm: sm, State: STATE_ENROUTE, SubmitDate: time.Now()})
+	smsc.scheduleDelivery("1", 30*time.Millisecond, server)
+
+	expected := []struct {
+		esmClass byte
+		state    byte
+	}{
+		{ESM_INTERMEDIATE, STATE_ENROUTE},
+		{ESM_INTERMEDIATE, STATE_ACCEPTED},
+		{ESM_SMSC_RECEIPT, STATE_DELIVERED},
+		{ESM_SME_DELIVERY_ACK, 0},
+	}
+	for _, e := range expected {
+		client.SetReadDeadline(time.Now().Add(time.Second))
+		head := make([]byte, 16)
+		if _, err := io.ReadFull(client, head); err != nil {
+			t.Fatalf("cannot read pdu: %v", err)
+		}
+		body := make([]byte, binary.BigEndian.Uint32(head)-16)
+		if _, err := io.ReadFull(client, body); err != nil {
+			t.Fatalf("cannot read pdu: %v", err)
+		}
+		// deliver_sm body has submit_sm layout, service_type of smscsim is skipped since it is too long for submit_sm
+		dlv, err := parseSubmitSm(append([]byte{0}, body[bytes.IndexByte(body, 0)+1:]...))
+		if err != nil {
+			t.Fatalf("cannot parse deliver_sm: %v", err)
+		}
+		if dlv.EsmClass != e.esmClass {
+			t.Fatalf("expected esm_class 0x%02X, got 0x%02X", e.esmClass, dlv.EsmClass)
+		}
+		if e.state != 0 {
+			if state, ok := findTlv(dlv.Tlvs, TLV_MESSAGE_STATE); !ok || state.Value[0] != e.state {
+				t.Errorf("expected message_state %s, got %+v", stateName(e.state), dlv.Tlvs)
+			}
+		} else if ref, ok := findTlv(dlv.Tlvs, TLV_USER_MSG_REF); !ok || ref.Value[1] != 0x07 {
+			t.Errorf("expected user_message_reference in acknowledgement, got %+v", dlv.Tlvs)
+		}
+	}
+}
+
+func TestReplaceDuringIntermediateNotifications(t *testing.T) {
+	smsc := NewSmsc(false)
+	smsc.Dlr.Delay = DlrDelay{DELAY_FIXED, 600 * time.Millisecond, 0}
+	client, server := net.Pipe()
+	defer client.Close()
+	client.SetReadDeadline(time.Now().Add(2 * time.Second))
+	sm := &SubmitSm{SourceAddr: "7701", DestinationAddr: "1001", RegisteredDelivery: REG_DLV_RECEIPT | REG_DLV_INTERMEDIATE}
+	smsc.Store.Add(Message{Id: "1", SystemId: "client1", Sm: sm, State: STATE_ENROUTE, SubmitDate: time.Now()})
+	smsc.scheduleDelivery("1", 150*time.Millisecond, server)
+
+	// first step is blocked on writing its notification until it is read
+	head := make([]byte, 16)
+	if _, err := io.ReadFull(client, head[:4]); err != nil {
+		t.Fatalf("cannot read pdu: %v", err)
+	}
+	replace := &ReplaceSm{MessageId: "1", SourceAddr: "7701", RegisteredDelivery: sm.RegisteredDelivery, ShortMessage: []byte("Replaced")}
+	if err := smsc.replaceMessage("client1", replace); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	var states []byte
+	for len(states) == 0 || states[len(states)-1] != STATE_DELIVERED {
+		if len(states) > 0 {
+			if _, err := io.ReadFull(client, head[:4]); err != nil {
+				t.Fatalf("cannot read pdu after %v: %v", states, err)
+			}
+		}
+		if _, err := io.ReadFull(client, head[4:]); err != nil {
+			t.Fatalf("cannot read pdu: %v", err)
+		}
+		body := make([]byte, binary.BigEndian.Uint32(head)-16)
+		if _, err := io.ReadFull(client, body); err != nil {
+			t.Fatalf("cannot read pdu: %v", err)
+		}
+		dlv, err := parseSubmitSm(append([]byte{0}, body[bytes.IndexByte(body, 0)+1:]...))
+		if err != nil {
+			t.Fatalf("cannot parse deliver_sm: %v", err)
+		}
+		state, _ := findTlv(dlv.Tlvs, TLV_MESSAGE_STATE)
+		states = append(states, state.Value[0])
+	}
+	// notification of the first schedule, then notifications and receipt of the replaced message only
+	expected := []byte{STATE_ENROUTE, STATE_ENROUTE, STATE_ACCEPTED, STATE_DELIVERED}
+	if !bytes.Equal(states, expected) {
+		t.Errorf("expected states %v, got %v", expected, states)
+	}
+}
+
+func TestReceiptRequested(t *testing.T) {
+	cases := []struct {
+		regDelivery byte
+		state       byte
+		requested   bool
+	}{
+		{0x00, STATE_DELIVERED, false},
+		{0x01, STATE_DELIVERED, true},
+		{0x01, STATE_UNDELIVERABLE, true},
+		{0x02, STATE_DELIVERED, false},
+		{0x02, STATE_EXPIRED, true},
+		{0x03, STATE_DELIVERED, true},
+		{0x03, STATE_REJECTED, false},
+		{0x10, STATE_DELIVERED, false},
+	}
+	for _, c := range cases {
+		if requested := receiptRequested(c.regDelivery, c.state); requested != c.requested {
+			t.Errorf("registered_delivery 0x%02X, state %s: expected %v, got %v", c.regDelivery, stateName(c.state), c.requested, requested)
+		}
+	}
+}
+
+// polls cond until it is true or timeout elapses
+func waitFor(timeout time.Duration, cond func() bool) bool {
+	deadline := time.Now().Add(timeout)
+	for !cond() {
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	return true
+}
+
+func TestScheduledAndExpiredMessages(t *testing.T) {
+	smsc := NewSmsc(false)
+	now := time.Now()
+	scheduled := &SubmitSm{ScheduleDeliveryTime: formatSmppTime(now.Add(time.Hour))}
+	expiring := &SubmitSm{ValidityPeriod: formatSmppTime(now.Add(200 * time.Millisecond))}
+	smsc.Store.Add(Message{Id: "1", Sm: scheduled, State: STATE_ENROUTE, SubmitDate: now})
+	smsc.Store.Add(Message{Id: "2", Sm: expiring, State: STATE_ENROUTE, SubmitDate: now})
+	smsc.scheduleDelivery("1", 10*time.Millisecond, nil)
+	smsc.scheduleDelivery("2", time.Hour, nil)
+
+	expired := waitFor(2*time.Second, func() bool {
+		msg, _ := smsc.Store.Get("2")
+		return msg.State == STATE_EXPIRED
+	})
+	if !expired {
+		t.Errorf("message should be expired")
+	}
+	// delivery delay of the scheduled message has elapsed by now
+	if msg, _ := smsc.Store.Get("1"); msg.State != STATE_ENROUTE {
+		t.Errorf("scheduled message should be pending, got %s", stateName(msg.State))
+	}
+	smsc.cancelMessages("", &CancelSm{MessageId: "1"})
+}
+
+func TestAcceptedOutcomeIsFinal(t *testing.T) {
+	smsc := NewSmsc(false)
+	outcomes, err := parseDlrOutcomes("ACCEPTD")
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	smsc.Dlr.Outcomes = outcomes
+	sm := &SubmitSm{SourceAddr: "7701", DestinationAddr: "1001"}
+	smsc.Store.Add(Message{Id: "1", SystemId: "client1", Sm: sm, State: STATE_ENROUTE, SubmitDate: time.Now()})
+	smsc.deliverMessage("1", 0, nil)
+
+	msg, _ := smsc.Store.Get("1")
+	if msg.State != STATE_ACCEPTED || !msg.IsFinal() || msg.FinalDate.IsZero() {
+		t.Errorf("message should be in final ACCEPTED state, got %s", stateName(msg.State))
+	}
+	if err := smsc.cancelMessages("client1", &CancelSm{MessageId: "1", SourceAddr: "7701"}); pduErrorStatus(err) != STS_CANCEL_FAIL {
+		t.Errorf("accepted message should not be cancelled, got %v", err)
+	}
+	if pending := smsc.Store.List(func(m *Message) bool { return !m.IsFinal() }); len(pending) != 0 {
+		t.Errorf("accepted message should not be pending")
+	}
+
+	// ACCEPTED after intermediate notification is still pending
+	smsc.Store.Add(Message{Id: "2", SystemId: "client1", Sm: sm, State: STATE_ACCEPTED, SubmitDate: time.Now()})
+	if msg, _ := smsc.Store.Get("2"); msg.IsFinal() {
+		t.Errorf("message without final date should be pending")
+	}
+	// no session is needed when no receipt is requested
+	if _, sent, err := smsc.DeliverManually("2", ManualDlr{State: STATE_ACCEPTED}); err != nil || sent {
+		t.Errorf("message should be moved to ACCEPTED state without receipt, got %v", err)
+	}
+}
+
+func TestReplaceReschedulesDelivery(t *testing.T) {
+	smsc := NewSmsc(false)
+	smsc.Dlr.Delay = DlrDelay{DELAY_FIXED, 10 * time.Millisecond, 0}
+	now := time.Now()
+	later := formatSmppTime(now.Add(time.Hour))
+	first := &SubmitSm{SourceAddr: "7701", DestinationAddr: "1001", ScheduleDeliveryTime: later}
+	second := &SubmitSm{SourceAddr: "7701", DestinationAddr: "1002", ScheduleDeliveryTime: later}
+	smsc.Store.Add(Message{Id: "1", SystemId: "client1", Sm: first, State: STATE_ENROUTE, SubmitDate: now})
+	smsc.Store.Add(Message{Id: "2", SystemId: "client1", Sm: second, State: STATE_ENROUTE, SubmitDate: now})
+	smsc.scheduleDelivery("1", 10*time.Millisecond, nil)
+	smsc.scheduleDelivery("2", 10*time.Millisecond, nil)
+
+	past := &ReplaceSm{MessageId: "1", SourceAddr: "7701", ValidityPeriod: formatSmppTime(now.Add(-time.Hour))}
+	if err := smsc.replaceMessage("client1", past); pduErrorStatus(err) != STS_INV_EXPIRY {
+		t.Errorf("validity_period in the past should be rejected, got %v", err)
+	}
+	if msg, _ := smsc.Store.Get("1"); msg.Sm.ValidityPeriod != "" {
+		t.Errorf("rejected replace_sm should not change the message")
+	}
+
+	replace := &ReplaceSm{MessageId: "1", SourceAddr: "7701", ScheduleDeliveryTime: formatSmppTime(now)}
+	if err := smsc.replaceMessage("client1", replace); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if id := smsc.replaceIfPresent("client1", &SubmitSm{SourceAddr: "7701", DestinationAddr: "1002"}); id != "2" {
+		t.Fatalf("expected message 2 to be replaced, got [%s]", id)
+	}
+
+	for _, id := range []string{"1", "2"} {
+		delivered := waitFor(2*time.Second, func() bool {
+			msg, _ := smsc.Store.Get(id)
+			return msg.State == STATE_DELIVERED
+		})
+		if !delivered {
+			t.Errorf("replaced message %s should be delivered with the new schedule", id)
+		}
+	}
+}
+
+func TestManualDlr(t *testing.T) {
+	smsc := NewSmsc(false)
+	smsc.ManualDlr = true
+	client, server := net.Pipe()
+	defer client.Close()
+	smsc.Sessions.Add(&Session{Id: 1, SystemId: "client1", ReceiveMo: true, conn: server, concatRefs: newConcatRefs()})
+	sm := &SubmitSm{SourceAddr: "7701", DestinationAddr: "1001", RegisteredDelivery: REG_DLV_RECEIPT}
+	smsc.Store.Add(Message{Id: "1", SystemId: "client1", Sm: sm, State: STATE_ENROUTE, SubmitDate: time.Now()})
+	smsc.scheduleDelivery("1", 10*time.Millisecond, server)
+
+	// no timer is armed, so the message cannot be delivered later
+	if msg, _ := smsc.Store.Get("1"); msg.State != STATE_ENROUTE || msg.timer != nil {
+		t.Fatalf("message should stay pending in manual DLR mode")
+	}
+	if _, _, err := smsc.DeliverManually("1", ManualDlr{State: STATE_ENROUTE}); err == nil {
+		t.Errorf("non-final state should be rejected")
+	}
+
+	submitDate := time.Date(2020, 1, 2, 3, 4, 0, 0, time.Local)
+	doneDate := time.Date(2020, 1, 2, 5, 6, 0, 0, time.Local)
+	result := make(chan bool, 1)
+	go func() {
+		_, sent, err := smsc.DeliverManually("1", ManualDlr{STATE_UNDELIVERABLE, 69, submitDate, doneDate})
+		result <- sent && err == nil
+	}()
+	client.SetReadDeadline(time.Now().Add(time.Second))
+	head := make([]byte, 16)
+	if _, err := io.ReadFull(client, head); err != nil {
+		t.Fatalf("cannot read pdu: %v", err)
+	}
+	body := make([]byte, binary.BigEndian.Uint32(head)-16)
+	if _, err := io.ReadFull(client, body); err != nil {
+		t.Fatalf("cannot read pdu: %v", err)
+	}
+	expected := "submit date:2001020304 done date:2001020506 stat:UNDELIV err:069"
+	if !bytes.Contains(body, []byte(expected)) {
+		t.Errorf("receipt should contain [%s], got [%s]", expected, body)
+	}
+	if !<-result {
+		t.Errorf("delivery receipt should be sent")
+	}
+	if msg, _ := smsc.Store.Get("1"); msg.State != STATE_UNDELIVERABLE || msg.ErrorCode != 69 || !msg.FinalDate.Equal(doneDate) {
+		t.Errorf("unexpected message %+v", msg)
+	}
+}
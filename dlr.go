@@ -0,0 +1,199 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// kinds of the delivery delay distribution
+const (
+	DELAY_FIXED   = iota // always Min
+	DELAY_UNIFORM        // uniformly distributed between Min and Max
+	DELAY_EXP            // exponentially distributed with mean Min
+)
+
+type DlrDelay struct {
+	Kind int
+	Min  time.Duration
+	Max  time.Duration
+}
+
+// DlrOutcome is a possible final state of the message with its error code
+type DlrOutcome struct {
+	State   byte
+	Err     int     // value of the err field of the delivery receipt
+	Percent float64 // probability of the outcome
+}
+
+// DlrPolicy controls when messages are delivered and which delivery receipts are sent
+type DlrPolicy struct {
+	Delay    DlrDelay
+	Outcomes []DlrOutcome
+}
+
+// stat values of the delivery receipt text
+var receiptStats = map[byte]string{
+	STATE_ENROUTE:       "ENROUTE",
+	STATE_DELIVERED:     "DELIVRD",
+	STATE_EXPIRED:       "EXPIRED",
+	STATE_DELETED:       "DELETED",
+	STATE_UNDELIVERABLE: "UNDELIV",
+	STATE_ACCEPTED:      "ACCEPTD",
+	STATE_UNKNOWN:       "UNKNOWN",
+	STATE_REJECTED:      "REJECTD",
+}
+
+func receiptStat(state byte) string {
+	if stat, ok := receiptStats[state]; ok {
+		return stat
+	}
+	return "UNKNOWN"
+}
+
+// every message is delivered after DELIVERY_DELAY
+func DefaultDlrPolicy() DlrPolicy {
+	return DlrPolicy{DlrDelay{DELAY_FIXED, DELIVERY_DELAY, 0}, []DlrOutcome{{STATE_DELIVERED, 0, 100}}}
+}
+
+// every message is undeliverable, used in FAILED_SUBMITS mode
+func FailedDlrPolicy() DlrPolicy {
+	return DlrPolicy{DlrDelay{DELAY_FIXED, DELIVERY_DELAY, 0}, []DlrOutcome{{STATE_UNDELIVERABLE, 69, 100}}}
+}
+
+func (d DlrDelay) Sample() time.Duration {
+	switch d.Kind {
+	case DELAY_UNIFORM:
+		return d.Min + time.Duration(rand.Int63n(int64(d.Max-d.Min)+1))
+	case DELAY_EXP:
+		return time.Duration(rand.ExpFloat64() * float64(d.Min))
+	default:
+		return d.Min
+	}
+}
+
+// pick random outcome according to the outcome percentages
+func (p DlrPolicy) Outcome() DlrOutcome {
+	total := 0.0
+	for _, o := range p.Outcomes {
+		total += o.Percent
+	}
+	if total <= 0 {
+		return DlrOutcome{STATE_DELIVERED, 0, 100}
+	}
+	r := rand.Float64() * total
+	for _, o := range p.Outcomes {
+		if r < o.Percent {
+			return o
+		}
+		r -= o.Percent
+	}
+	return p.Outcomes[len(p.Outcomes)-1]
+}
+
+// parse delay definition: "2s" (fixed), "1s-5s" (uniform) or "exp:3s" (exponential with 3s mean)
+func parseDlrDelay(def string) (DlrDelay, error) {
+	def = strings.TrimSpace(def)
+	if strings.HasPrefix(def, "exp:") {
+		mean, err := time.ParseDuration(def[len("exp:"):])
+		if err != nil || mean < 0 {
+			return DlrDelay{}, fmt.Errorf("invalid delay definition [%s]", def)
+		}
+		return DlrDelay{DELAY_EXP, mean, 0}, nil
+	}
+	bounds := strings.Split(def, "-")
+	if len(bounds) > 2 {
+		return DlrDelay{}, fmt.Errorf("invalid delay definition [%s]", def)
+	}
+	min, err := time.ParseDuration(bounds[0])
+	if err != nil || min < 0 {
+		return DlrDelay{}, fmt.Errorf("invalid delay definition [%s]", def)
+	}
+	if len(bounds) == 1 {
+		return DlrDelay{DELAY_FIXED, min, 0}, nil
+	}
+	max, err := time.ParseDuration(bounds[1])
+	if err != nil || max < min {
+		return DlrDelay{}, fmt.Errorf("invalid delay definition [%s]", def)
+	}
+	return DlrDelay{DELAY_UNIFORM, min, max}, nil
+}
+
+// parse comma separated list of outcomes in format "STAT[:ERR][@PERCENT]", e.g. "DELIVRD@80,UNDELIV:069@15,EXPIRED".
+// Outcomes without percentage share equally what is left up to 100%
+func parseDlrOutcomes(defs string) ([]DlrOutcome, error) {
+	var outcomes []DlrOutcome
+	var unweighted []int
+	total := 0.0
+	for _, def := range strings.Split(defs, ",") {
+		def = strings.TrimSpace(def)
+		if def == "" {
+			continue
+		}
+		outcome, weighted, err := parseDlrOutcome(def)
+		if err != nil {
+			return nil, err
+		}
+		if weighted {
+			total += outcome.Percent
+		} else {
+			unweighted = append(unweighted, len(outcomes))
+		}
+		outcomes = append(outcomes, outcome)
+	}
+	if len(outcomes) == 0 {
+		return nil, fmt.Errorf("no outcomes defined")
+	}
+	if total > 100 {
+		return nil, fmt.Errorf("outcome percentages sum up to %v%%", total)
+	}
+	if len(unweighted) > 0 {
+		if total == 100 {
+			return nil, fmt.Errorf("no percentage left for outcomes without percentage")
+		}
+		for _, i := range unweighted {
+			outcomes[i].Percent = (100 - total) / float64(len(unweighted))
+		}
+	}
+	return outcomes, nil
+}
+
+func parseDlrOutcome(def string) (DlrOutcome, bool, error) {
+	var outcome DlrOutcome
+	weighted := false
+	if i := strings.Index(def, "@"); i >= 0 {
+		percent, err := strconv.ParseFloat(strings.TrimSuffix(def[i+1:], "%"), 64)
+		if err != nil || percent < 0 || percent > 100 {
+			return outcome, false, fmt.Errorf("invalid percentage in outcome [%s]", def)
+		}
+		outcome.Percent = percent
+		weighted = true
+		def = def[:i]
+	}
+	stat := def
+	if i := strings.Index(def, ":"); i >= 0 {
+		code, err := strconv.Atoi(def[i+1:])
+		if err != nil || code < 0 || code > 999 {
+			return outcome, false, fmt.Errorf("invalid err code in outcome [%s]", def)
+		}
+		outcome.Err = code
+		stat = def[:i]
+	}
+	state, ok := finalStateByStat(strings.ToUpper(stat))
+	if !ok {
+		return outcome, false, fmt.Errorf("invalid stat [%s]", stat)
+	}
+	outcome.State = state
+	return outcome, weighted, nil
+}
+
+func finalStateByStat(stat string) (byte, bool) {
+	for state, s := range receiptStats {
+		if s == stat && state != STATE_ENROUTE {
+			return state, true
+		}
+	}
+	return 0, false
+}
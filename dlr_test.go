@@ -0,0 +1,85 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestParseDlrOutcomes(t *testing.T) {
+	outcomes, err := parseDlrOutcomes("DELIVRD@80, UNDELIV:069@10,EXPIRED:001,REJECTD")
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	expected := []DlrOutcome{
+		{STATE_DELIVERED, 0, 80},
+		{STATE_UNDELIVERABLE, 69, 10},
+		{STATE_EXPIRED, 1, 5},
+		{STATE_REJECTED, 0, 5},
+	}
+	if len(outcomes) != len(expected) {
+		t.Fatalf("expected %+v, got %+v", expected, outcomes)
+	}
+	for i := range expected {
+		if outcomes[i] != expected[i] {
+			t.Errorf("expected %+v, got %+v", expected[i], outcomes[i])
+		}
+	}
+
+	for _, def := range []string{"", "DELIVRD@60,UNDELIV@50", "ENROUTE", "DELIVRD:abc", "DELIVRD@100,EXPIRED"} {
+		if _, err := parseDlrOutcomes(def); err == nil {
+			t.Errorf("outcomes [%s] should not be parsed", def)
+		}
+	}
+}
+
+func TestParseDlrDelay(t *testing.T) {
+	cases := []struct {
+		def   string
+		delay DlrDelay
+	}{
+		{"2s", DlrDelay{DELAY_FIXED, 2 * time.Second, 0}},
+		{"1s-5s", DlrDelay{DELAY_UNIFORM, time.Second, 5 * time.Second}},
+		{"exp:300ms", DlrDelay{DELAY_EXP, 300 * time.Millisecond, 0}},
+	}
+	for _, c := range cases {
+		delay, err := parseDlrDelay(c.def)
+		if err != nil {
+			t.Errorf("delay [%s]: unexpected error %v", c.def, err)
+		} else if delay != c.delay {
+			t.Errorf("delay [%s]: expected %+v, got %+v", c.def, c.delay, delay)
+		}
+	}
+
+	if _, err := parseDlrDelay("5s-1s"); err == nil {
+		t.Errorf("delay with max < min should not be parsed")
+	}
+
+	uniform := DlrDelay{DELAY_UNIFORM, time.Second, 2 * time.Second}
+	for i := 0; i < 100; i++ {
+		if d := uniform.Sample(); d < time.Second || d > 2*time.Second {
+			t.Fatalf("delay %v is out of range", d)
+		}
+	}
+}
+
+func TestDlrOutcome(t *testing.T) {
+	policy := DlrPolicy{Outcomes: []DlrOutcome{{STATE_DELIVERED, 0, 0}, {STATE_EXPIRED, 1, 100}}}
+	for i := 0; i < 100; i++ {
+		if outcome := policy.Outcome(); outcome.State != STATE_EXPIRED {
+			t.Fatalf("expected EXPIRED outcome, got %+v", outcome)
+		}
+	}
+}
+
+func TestDeliveryReceiptContent(t *testing.T) {
+	date := time.Date(2020, 5, 17, 10, 30, 0, 0, time.UTC)
+	text, tlvs := deliveryReceiptContent("123", date, date, STATE_EXPIRED, 1)
+	expected := []byte("id:123 sub:001 dlvrd:000 submit date:2005171030 done date:2005171030 stat:EXPIRED err:001 Text:...")
+	if !bytes.Equal(text, expected) {
+		t.Errorf("expected [%s], got [%s]", expected, text)
+	}
+	if state, ok := findTlv(tlvs, TLV_MESSAGE_STATE); !ok || state.Value[0] != STATE_EXPIRED {
+		t.Errorf("expected message_state TLV with EXPIRED state, got %+v", tlvs)
+	}
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"strings"
+)
+
+// GSM 03.38 default alphabet, index of the character is its septet value.
+// 0x1B is an escape to the extension table
+var gsm7Basic = []rune("@£$¥èéùìòÇ\nØø\rÅåΔ_ΦΓΛΩΠΨΣΘΞ\x1bÆæßÉ !\"#¤%&'()*+,-./0123456789:;<=>?" +
+	"¡ABCDEFGHIJKLMNOPQRSTUVWXYZÄÖÑÜ§¿abcdefghijklmnopqrstuvwxyzäöñüà")
+
+// GSM 03.38 extension table, characters are encoded as escape followed by the septet
+var gsm7Extension = map[rune]byte{
+	'\f': 0x0A,
+	'^':  0x14,
+	'{':  0x28,
+	'}':  0x29,
+	'\\': 0x2F,
+	'[':  0x3C,
+	'~':  0x3D,
+	']':  0x3E,
+	'|':  0x40,
+	'€':  0x65,
+}
+
+const GSM7_ESCAPE = 0x1B
+
+// max number of septets in a single message
+const GSM7_MAX_SEPTETS = 160
+
+var gsm7BasicIndex = func() map[rune]byte {
+	index := make(map[rune]byte, len(gsm7Basic))
+	for i, r := range gsm7Basic {
+		if i != GSM7_ESCAPE {
+			index[r] = byte(i)
+		}
+	}
+	return index
+}()
+
+// encode text to unpacked septets, one septet per octet.
+// Returns false if text has characters missing in the default alphabet
+func encodeGsm7(text string) ([]byte, bool) {
+	septets := make([]byte, 0, len(text))
+	for _, r := range text {
+		if s, ok := gsm7BasicIndex[r]; ok {
+			septets = append(septets, s)
+		} else if s, ok := gsm7Extension[r]; ok {
+			septets = append(septets, GSM7_ESCAPE, s)
+		} else {
+			return nil, false
+		}
+	}
+	return septets, true
+}
+
+// decode unpacked septets. Unknown extension characters are decoded as space
+func decodeGsm7(septets []byte) string {
+	var sb strings.Builder
+	for i := 0; i < len(septets); i++ {
+		s := septets[i] & 0x7F
+		if s != GSM7_ESCAPE {
+			sb.WriteRune(gsm7Basic[s])
+			continue
+		}
+		if i+1 == len(septets) {
+			break
+		}
+		i++
+		ext := ' '
+		for r, e := range gsm7Extension {
+			if e == septets[i]&0x7F {
+				ext = r
+			}
+		}
+		sb.WriteRune(ext)
+	}
+	return sb.String()
+}
+
+// pack septets into octets starting after fillBits padding bits (used to align septets after UDH).
+// If 7 bits are left unused in the last octet, they are filled with CR to not be read as '@'
+func packGsm7(septets []byte, fillBits int) []byte {
+	totalBits := fillBits + len(septets)*7
+	packed := make([]byte, (totalBits+7)/8)
+	bit := fillBits
+	put := func(s byte) {
+		v := uint16(s&0x7F) << uint(bit%8)
+		packed[bit/8] |= byte(v)
+		if bit%8 > 1 {
+			packed[bit/8+1] |= byte(v >> 8)
+		}
+		bit += 7
+	}
+	for _, s := range septets {
+		put(s)
+	}
+	if len(packed)*8-totalBits == 7 {
+		put('\r')
+	}
+	return packed
+}
+
+// unpack septets from octets skipping fillBits padding bits. CR padding in the last octet is removed
+func unpackGsm7(packed []byte, fillBits int) []byte {
+	count := (len(packed)*8 - fillBits) / 7
+	if count < 0 {
+		return nil
+	}
+	septets := make([]byte, count)
+	for i := range septets {
+		bit := fillBits + i*7
+		v := uint16(packed[bit/8]) >> uint(bit%8)
+		if bit%8 > 1 {
+			v |= uint16(packed[bit/8+1]) << uint(8-bit%8)
+		}
+		septets[i] = byte(v & 0x7F)
+	}
+	if count > 0 && fillBits+count*7 == len(packed)*8 && septets[count-1] == '\r' {
+		septets = septets[:count-1]
+	}
+	return septets
+}
+
+// split septets into parts of concatenated message with UDH of udhLen
+// octets (0 for SAR segmentation) without splitting escape sequences
+func splitGsm7(septets []byte, udhLen int) [][]byte {
+	if len(septets) <= GSM7_MAX_SEPTETS {
+		return [][]byte{septets}
+	}
+	maxPartSeptets := (140 - udhLen) * 8 / 7
+	var parts [][]byte
+	for len(septets) > 0 {
+		n := maxPartSeptets
+		if n >= len(septets) {
+			n = len(septets)
+		} else if septets[n-1] == GSM7_ESCAPE {
+			n--
+		}
+		parts = append(parts, septets[:n])
+		septets = septets[n:]
+	}
+	return parts
+}
+
+// number of padding bits which align packed septets after UDH of udhLen octets
+func gsm7FillBits(udhLen int) int {
+	return (7 - udhLen*8%7) % 7
+}
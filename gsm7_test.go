@@ -0,0 +1,69 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"testing"
+)
+
+func TestGsm7Encoding(t *testing.T) {
+	if len(gsm7Basic) != 128 {
+		t.Fatalf("default alphabet should have 128 characters, got %d", len(gsm7Basic))
+	}
+
+	text := "Hello @ 5€ {ok} Øre"
+	septets, ok := encodeGsm7(text)
+	if !ok {
+		t.Fatalf("text [%s] should be encoded with GSM7", text)
+	}
+	if len(septets) != 22 { // euro sign and braces take two septets
+		t.Errorf("expected 22 septets, got %d", len(septets))
+	}
+	if decoded := decodeGsm7(septets); decoded != text {
+		t.Errorf("expected [%s], got [%s]", text, decoded)
+	}
+	if _, ok := encodeGsm7("Привет"); ok {
+		t.Errorf("cyrillic text should not be encoded with GSM7")
+	}
+}
+
+func TestGsm7Packing(t *testing.T) {
+	septets, _ := encodeGsm7("hellohello")
+	expectedBytes := []byte{0xE8, 0x32, 0x9B, 0xFD, 0x46, 0x97, 0xD9, 0xEC, 0x37}
+	actualBytes := packGsm7(septets, 0)
+	if !bytes.Equal(expectedBytes, actualBytes) {
+		fmt.Printf("expected: [%s]\nactual: [%s]\n\n", hex.EncodeToString(expectedBytes), hex.EncodeToString(actualBytes))
+		t.Errorf("GSM7 septets incorrectly packed")
+	}
+
+	for _, text := range []string{"hellohello", "1234567", "12345678", "x"} {
+		for fillBits := 0; fillBits < 7; fillBits++ {
+			septets, _ := encodeGsm7(text)
+			if unpacked := decodeGsm7(unpackGsm7(packGsm7(septets, fillBits), fillBits)); unpacked != text {
+				t.Errorf("text [%s] with %d fill bits: got [%s] after packing", text, fillBits, unpacked)
+			}
+		}
+	}
+}
+
+func TestGsm7MoParts(t *testing.T) {
+	smsc := NewSmsc(false)
+	text := ""
+	for len(text) < 152 {
+		text += "a"
+	}
+	text += "€" // escape sequence should not be split between parts
+	text += "bbbbbbbbbb"
+	parts, coding, _ := smsc.moParts(text, MoOptions{}, 1)
+	if coding != CODING_DEFAULT || len(parts) != 2 {
+		t.Fatalf("expected 2 GSM7 parts, got %d parts with coding 0x%02X", len(parts), coding)
+	}
+	if len(parts[0].ShortMessage) != 6+152 || parts[0].ShortMessage[4] != 2 || parts[1].ShortMessage[5] != 2 {
+		t.Errorf("unexpected parts %v", parts)
+	}
+
+	if parts, coding, _ := smsc.moParts("Привет", MoOptions{}, 1); coding != CODING_UCS2 || len(parts) != 1 {
+		t.Errorf("cyrillic text should be sent as single UCS2 message")
+	}
+}
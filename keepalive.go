@@ -0,0 +1,88 @@
+package main
+
+import (
+	"log"
+	"math/rand"
+	"net"
+	"time"
+)
+
+const DEFAULT_RESPONSE_TIMEOUT = 10 * time.Second
+
+// SessionTimers control server side link checks and idle session timeouts.
+// Zero value disables corresponding timer
+type SessionTimers struct {
+	EnquireLinkInterval time.Duration // how often smscsim sends enquire_link to the bound session
+	ResponseTimeout     time.Duration // how long to wait for enquire_link_resp and unbind_resp
+	InactivityTimeout   time.Duration // bound session without requests from the ESME is unbound after this timeout
+	SessionInitTimeout  time.Duration // connection without successful bind is closed after this timeout
+}
+
+// read deadline for the next PDU from the ESME. Inactivity is counted from the last request
+// of the ESME, responses to enquire_link and other PDUs sent by smscsim do not reset it
+func (t SessionTimers) readDeadline(bound bool, openedAt, lastRequestAt, unbindSentAt time.Time) time.Time {
+	switch {
+	case !unbindSentAt.IsZero():
+		return unbindSentAt.Add(t.ResponseTimeout)
+	case !bound && t.SessionInitTimeout > 0:
+		return openedAt.Add(t.SessionInitTimeout)
+	case bound && t.InactivityTimeout > 0:
+		return lastRequestAt.Add(t.InactivityTimeout)
+	default:
+		return time.Time{} // no deadline
+	}
+}
+
+func isTimeout(err error) bool {
+	netErr, ok := err.(net.Error)
+	return ok && netErr.Timeout()
+}
+
+// periodically send enquire_link to the ESME and close connection if enquire_link_resp
+// is not received in time. Sequence numbers of received enquire_link_resp PDUs are read
+// from the resps channel. Loop is stopped when stop channel is closed
+func (smsc *Smsc) enquireLinkLoop(conn net.Conn, systemId string, resps <-chan uint32, stop <-chan struct{}) {
+	interval := smsc.Timers.EnquireLinkInterval
+	timeout := smsc.Timers.ResponseTimeout
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+		select {
+		case <-stop:
+			return // both channels were ready, session is unbound
+		default:
+		}
+
+		seqNum := uint32(rand.Int31())
+		if _, err := conn.Write(headerPDU(ENQUIRE_LINK, STS_OK, seqNum)); err != nil {
+			log.Printf("error sending enquire_link to system_id[%s] due %v", systemId, err)
+			return
+		}
+		log.Printf("enquire_link was sent to system_id[%s]", systemId)
+
+		timer := time.NewTimer(timeout)
+	waitResp:
+		for {
+			select {
+			case <-stop:
+				timer.Stop()
+				return
+			case respSeqNum := <-resps:
+				if respSeqNum == seqNum {
+					timer.Stop()
+					break waitResp
+				}
+			case <-timer.C:
+				log.Printf("no enquire_link_resp from system_id[%s] in %v. closing connection", systemId, timeout)
+				conn.Close()
+				return
+			}
+		}
+	}
+}
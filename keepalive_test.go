@@ -0,0 +1,133 @@
+package main
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestSessionInitTimeout(t *testing.T) {
+	smsc := NewSmsc(false)
+	smsc.Timers.SessionInitTimeout = 50 * time.Millisecond
+	client, server := net.Pipe()
+	go handleSmppConnection(smsc, server)
+
+	client.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := client.Read(make([]byte, 1)); err != io.EOF {
+		t.Errorf("expected connection to be closed, got %v", err)
+	}
+}
+
+func TestInactivityTimeout(t *testing.T) {
+	smsc := NewSmsc(false)
+	smsc.Timers.InactivityTimeout = 50 * time.Millisecond
+	smsc.Timers.ResponseTimeout = 50 * time.Millisecond
+	client, server := net.Pipe()
+	go handleSmppConnection(smsc, server)
+
+	client.Write(bindTransceiverPDU("client1"))
+	if cmdId, _ := readPduHeader(t, client); cmdId != BIND_TRANSCEIVER_RESP {
+		t.Fatalf("expected bind_transceiver_resp, got 0x%08X", cmdId)
+	}
+	if cmdId, _ := readPduHeader(t, client); cmdId != UNBIND {
+		t.Fatalf("expected unbind, got 0x%08X", cmdId)
+	}
+	// do not answer unbind, connection should be closed
+	if _, err := client.Read(make([]byte, 1)); err != io.EOF {
+		t.Errorf("expected connection to be closed, got %v", err)
+	}
+}
+
+func TestServerEnquireLink(t *testing.T) {
+	smsc := NewSmsc(false)
+	smsc.Timers.EnquireLinkInterval = 20 * time.Millisecond
+	smsc.Timers.ResponseTimeout = 50 * time.Millisecond
+	client, server := net.Pipe()
+	go handleSmppConnection(smsc, server)
+
+	client.Write(bindTransceiverPDU("client1"))
+	readPduHeader(t, client) // bind_transceiver_resp
+
+	// answer first enquire_link and ignore the second one
+	cmdId, seqNum := readPduHeader(t, client)
+	if cmdId != ENQUIRE_LINK {
+		t.Fatalf("expected enquire_link, got 0x%08X", cmdId)
+	}
+	client.Write(headerPDU(ENQUIRE_LINK_RESP, STS_OK, seqNum))
+	if cmdId, _ := readPduHeader(t, client); cmdId != ENQUIRE_LINK {
+		t.Fatalf("expected enquire_link, got 0x%08X", cmdId)
+	}
+	if _, err := client.Read(make([]byte, 1)); err != io.EOF {
+		t.Errorf("expected connection to be closed, got %v", err)
+	}
+}
+
+func TestEnquireLinkStopsOnUnbind(t *testing.T) {
+	smsc := NewSmsc(false)
+	smsc.Timers.EnquireLinkInterval = 20 * time.Millisecond
+	smsc.Timers.ResponseTimeout = time.Second
+	client, server := net.Pipe()
+	defer client.Close()
+	go handleSmppConnection(smsc, server)
+
+	client.Write(bindTransceiverPDU("client1"))
+	readPduHeader(t, client) // bind_transceiver_resp
+	client.Write(headerPDU(UNBIND, STS_OK, 2))
+	for {
+		cmdId, _ := readPduHeader(t, client)
+		if cmdId == UNBIND_RESP {
+			break
+		}
+	}
+	client.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	if _, err := client.Read(make([]byte, 1)); !isTimeout(err) {
+		t.Fatalf("no enquire_link should be sent to unbound session, got %v", err)
+	}
+
+	client.Write(bindTransceiverPDU("client1"))
+	readPduHeader(t, client) // bind_transceiver_resp
+	if cmdId, _ := readPduHeader(t, client); cmdId != ENQUIRE_LINK {
+		t.Errorf("enquire_link should be sent again after rebind, got 0x%08X", cmdId)
+	}
+}
+
+func TestUnexpectedUnbindResp(t *testing.T) {
+	smsc := NewSmsc(false)
+	client, server := net.Pipe()
+	defer client.Close()
+	go handleSmppConnection(smsc, server)
+
+	client.Write(bindTransceiverPDU("client1"))
+	readPduHeader(t, client) // bind_transceiver_resp
+	client.Write(headerPDU(UNBIND_RESP, STS_OK, 2))
+	go client.Write(headerPDU(ENQUIRE_LINK, STS_OK, 3)) // net.Pipe write blocks until it is read
+	if cmdId, _ := readPduHeader(t, client); cmdId != ENQUIRE_LINK_RESP {
+		t.Errorf("unbind_resp without unbind should be ignored, got 0x%08X", cmdId)
+	}
+}
+
+func TestInactivityTimeoutWithEnquireLink(t *testing.T) {
+	smsc := NewSmsc(false)
+	smsc.Timers.EnquireLinkInterval = 20 * time.Millisecond
+	smsc.Timers.InactivityTimeout = 100 * time.Millisecond
+	smsc.Timers.ResponseTimeout = time.Second
+	client, server := net.Pipe()
+	defer client.Close()
+	go handleSmppConnection(smsc, server)
+
+	client.Write(bindTransceiverPDU("client1"))
+	readPduHeader(t, client) // bind_transceiver_resp
+	// answered enquire_link PDUs of smscsim are not an activity of the ESME
+	deadline := time.Now().Add(time.Second)
+	for {
+		if time.Now().After(deadline) {
+			t.Fatalf("session without requests should be unbound")
+		}
+		cmdId, seqNum := readPduHeader(t, client)
+		if cmdId == UNBIND {
+			break
+		}
+		go client.Write(headerPDU(ENQUIRE_LINK_RESP, STS_OK, seqNum)) // net.Pipe write blocks until it is read
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"os"
 	"strconv"
 	"sync"
+	"time"
 )
 
 var wg sync.WaitGroup
@@ -13,11 +14,24 @@ func main() {
 	smscPort := getPort("SMSC_PORT", 2775)
 	webPort := getPort("WEB_PORT", 12775)
 	failedSubmits := "true" == os.Getenv("FAILED_SUBMITS")
+	accounts := getAccounts()
 
 	wg.Add(2)
 
 	// start smpp server
 	smsc := NewSmsc(failedSubmits)
+	smsc.Accounts = accounts
+	smsc.Unsuccess = getUnsuccessRules()
+	smsc.Timers = SessionTimers{
+		EnquireLinkInterval: getDuration("ENQUIRE_LINK_INTERVAL", 0),
+		ResponseTimeout:     getPositiveDuration("RESPONSE_TIMEOUT", DEFAULT_RESPONSE_TIMEOUT),
+		InactivityTimeout:   getDuration("INACTIVITY_TIMEOUT", 0),
+		SessionInitTimeout:  getDuration("SESSION_INIT_TIMEOUT", 0),
+	}
+	smsc.Dlr = getDlrPolicy(smsc.Dlr)
+	smsc.Routes = getRoutes()
+	smsc.Gsm7Packed = "true" == os.Getenv("GSM7_PACKED")
+	smsc.ManualDlr = "true" == os.Getenv("DLR_MANUAL")
 	go smsc.Start(smscPort, &wg)
 
 	// start web server
@@ -40,3 +54,79 @@ func getPort(envVar string, defVal int) int {
 	}
 	return port
 }
+
+func getDuration(envVar string, defVal time.Duration) time.Duration {
+	duration := defVal
+	durationStr := os.Getenv(envVar)
+	if durationStr != "" {
+		d, err := time.ParseDuration(durationStr)
+		if err != nil || d < 0 {
+			log.Fatalf("invalid duration %s [%s]", envVar, durationStr)
+		} else {
+			duration = d
+		}
+	}
+	return duration
+}
+
+// same as getDuration, but zero duration is rejected
+func getPositiveDuration(envVar string, defVal time.Duration) time.Duration {
+	duration := getDuration(envVar, defVal)
+	if duration == 0 {
+		log.Fatalf("invalid duration %s [%s], positive duration expected", envVar, os.Getenv(envVar))
+	}
+	return duration
+}
+
+func getAccounts() *Accounts {
+	accounts, err := LoadAccounts(os.Getenv("ACCOUNTS_FILE"), os.Getenv("ACCOUNTS"))
+	if err != nil {
+		log.Fatalf("cannot load accounts: %v", err)
+	}
+	switch mode := os.Getenv("BIND_AUTH"); mode {
+	case "":
+		// keep default mode
+	case "permissive":
+		accounts.Permissive = true
+	case "strict":
+		accounts.Permissive = false
+	default:
+		log.Fatalf("invalid BIND_AUTH [%s]", mode)
+	}
+	return accounts
+}
+
+func getUnsuccessRules() []UnsuccessRule {
+	rules, err := parseUnsuccessRules(os.Getenv("UNSUCCESS_SME"))
+	if err != nil {
+		log.Fatalf("invalid UNSUCCESS_SME: %v", err)
+	}
+	return rules
+}
+
+// override delay and outcomes of the default policy with DLR_DELAY and DLR_OUTCOMES
+func getDlrPolicy(policy DlrPolicy) DlrPolicy {
+	if def := os.Getenv("DLR_DELAY"); def != "" {
+		delay, err := parseDlrDelay(def)
+		if err != nil {
+			log.Fatalf("invalid DLR_DELAY: %v", err)
+		}
+		policy.Delay = delay
+	}
+	if defs := os.Getenv("DLR_OUTCOMES"); defs != "" {
+		outcomes, err := parseDlrOutcomes(defs)
+		if err != nil {
+			log.Fatalf("invalid DLR_OUTCOMES: %v", err)
+		}
+		policy.Outcomes = outcomes
+	}
+	return policy
+}
+
+func getRoutes() Routes {
+	routes, err := LoadRoutes(os.Getenv("ROUTES_FILE"), os.Getenv("ROUTES"))
+	if err != nil {
+		log.Fatalf("cannot load routes: %v", err)
+	}
+	return routes
+}
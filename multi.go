@@ -0,0 +1,129 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math/rand"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// failed destination reported in submit_multi_resp
+type UnsuccessSme struct {
+	Ton    byte
+	Npi    byte
+	Addr   string
+	Status uint32
+}
+
+// submit_multi destinations matching the pattern are rejected with the given status
+type UnsuccessRule struct {
+	Pattern string
+	Status  uint32
+}
+
+// parse comma separated list of "pattern[:status]" definitions. Pattern is either
+// an exact address or a prefix ended with '*'. Status defaults to ESME_RSUBMITFAIL
+func parseUnsuccessRules(defs string) ([]UnsuccessRule, error) {
+	var rules []UnsuccessRule
+	for _, def := range strings.Split(defs, ",") {
+		def = strings.TrimSpace(def)
+		if def == "" {
+			continue
+		}
+		rule := UnsuccessRule{def, STS_SUBMIT_FAIL}
+		if idx := strings.LastIndex(def, ":"); idx != -1 {
+			sts, err := strconv.ParseUint(def[idx+1:], 0, 32)
+			if err != nil {
+				return nil, fmt.Errorf("invalid status in unsuccess_sme definition [%s]", def)
+			}
+			rule = UnsuccessRule{def[:idx], uint32(sts)}
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+// address pattern is either an exact address or a prefix ended with '*'
+func matchAddr(pattern, addr string) bool {
+	if strings.HasSuffix(pattern, "*") {
+		return strings.HasPrefix(addr, pattern[:len(pattern)-1])
+	}
+	return pattern == addr
+}
+
+func (smsc *Smsc) unsuccessStatus(addr string) uint32 {
+	for _, rule := range smsc.Unsuccess {
+		if matchAddr(rule.Pattern, addr) {
+			return rule.Status
+		}
+	}
+	return STS_OK
+}
+
+// store message for every accepted destination of the submit_multi and schedule its delivery.
+// Returns message_id shared by all destinations and list of failed destinations
+func (smsc *Smsc) submitMulti(systemId string, sm *SubmitMulti, conn net.Conn) (string, []UnsuccessSme) {
+	msgId := strconv.Itoa(rand.Int())
+	submitDate := time.Now()
+	var unsuccess []UnsuccessSme
+
+	for _, dest := range sm.Dests {
+		if dest.DestFlag == DEST_FLAG_DL {
+			// distribution lists are not supported by smscsim
+			unsuccess = append(unsuccess, UnsuccessSme{0, 0, dest.Addr, STS_INV_DL_NAME})
+			continue
+		}
+		if dest.Addr == "" {
+			unsuccess = append(unsuccess, UnsuccessSme{dest.Ton, dest.Npi, dest.Addr, STS_INV_DST_ADR})
+			continue
+		}
+		if sts := smsc.unsuccessStatus(dest.Addr); sts != STS_OK {
+			unsuccess = append(unsuccess, UnsuccessSme{dest.Ton, dest.Npi, dest.Addr, sts})
+			continue
+		}
+
+		recipientSm := sm.SubmitSm
+		recipientSm.DestAddrTon = dest.Ton
+		recipientSm.DestAddrNpi = dest.Npi
+		recipientSm.DestinationAddr = dest.Addr
+		route := smsc.Routes.Find(systemId, &recipientSm)
+		if route != nil && route.Status != STS_OK {
+			unsuccess = append(unsuccess, UnsuccessSme{dest.Ton, dest.Npi, dest.Addr, route.Status})
+			continue
+		}
+		key := smsc.Store.Add(Message{Id: msgId, CmdId: SUBMIT_MULTI, SystemId: systemId, Sm: &recipientSm, State: STATE_ENROUTE, SubmitDate: submitDate})
+		smsc.addSegment(systemId, msgId, &recipientSm)
+		smsc.scheduleDelivery(key, smsc.dlrPolicy(route).Delay.Sample(), conn)
+	}
+
+	log.Printf("submit_multi [%s] from system_id[%s] accepted for %d of %d destinations", msgId, systemId, len(sm.Dests)-len(unsuccess), len(sm.Dests))
+	return msgId, unsuccess
+}
+
+// message state for query_sm. Recipients of the submit_multi share one message_id, so the message is
+// pending while any recipient is pending. Otherwise it has the state of the first recipient which was
+// not delivered (DELIVERED if all of them were delivered) and the latest final date of the recipients
+func (smsc *Smsc) queryMessage(msgId string) (Message, bool) {
+	recipients := smsc.Store.GetAll(msgId)
+	if len(recipients) == 0 {
+		return Message{}, false
+	}
+	msg := recipients[0]
+	failed := false
+	for _, m := range recipients {
+		if !m.IsFinal() {
+			return m, true
+		}
+		if !failed && m.State != STATE_DELIVERED {
+			msg.State, msg.ErrorCode = m.State, m.ErrorCode
+			failed = true
+		}
+		if m.FinalDate.After(msg.FinalDate) {
+			msg.FinalDate = m.FinalDate
+		}
+	}
+	return msg, true
+}
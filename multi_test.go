@@ -0,0 +1,44 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestQuerySubmitMultiMessage(t *testing.T) {
+	smsc := NewSmsc(false)
+	sm := &SubmitMulti{SubmitSm: SubmitSm{SourceAddr: "7701"}, Dests: []DestAddress{
+		{DestFlag: DEST_FLAG_SME, Addr: "1001"},
+		{DestFlag: DEST_FLAG_SME, Addr: "1002"},
+		{DestFlag: DEST_FLAG_SME, Addr: "1003"},
+	}}
+	msgId, _ := smsc.submitMulti("client1", sm, nil)
+	recipients := smsc.Store.GetAll(msgId)
+	if len(recipients) != 3 || recipients[2].Sm.DestinationAddr != "1003" {
+		t.Fatalf("expected message for every destination, got %+v", recipients)
+	}
+
+	now := time.Now()
+	finish := func(key string, state byte, errCode int, finalDate time.Time) {
+		smsc.Store.Update(key, func(m *Message) {
+			if m.timer != nil {
+				m.timer.Stop()
+			}
+			m.State, m.ErrorCode, m.FinalDate = state, errCode, finalDate
+		})
+	}
+	finish(recipients[0].Key, STATE_DELIVERED, 0, now)
+	finish(recipients[1].Key, STATE_UNDELIVERABLE, 69, now.Add(time.Second))
+	if msg, _ := smsc.queryMessage(msgId); msg.State != STATE_ENROUTE || msg.IsFinal() {
+		t.Errorf("message should be pending while any recipient is pending, got %s", stateName(msg.State))
+	}
+
+	finish(recipients[2].Key, STATE_DELIVERED, 0, now.Add(2*time.Second))
+	msg, ok := smsc.queryMessage(msgId)
+	if !ok || msg.State != STATE_UNDELIVERABLE || msg.ErrorCode != 69 || !msg.FinalDate.Equal(now.Add(2*time.Second)) {
+		t.Errorf("expected UNDELIVERABLE state with the latest final date, got %+v", msg)
+	}
+	if _, ok := smsc.queryMessage("unknown"); ok {
+		t.Errorf("unknown message should not be found")
+	}
+}
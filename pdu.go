@@ -0,0 +1,619 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"time"
+)
+
+type SubmitSm struct {
+	ServiceType          string
+	SourceAddrTon        byte
+	SourceAddrNpi        byte
+	SourceAddr           string
+	DestAddrTon          byte
+	DestAddrNpi          byte
+	DestinationAddr      string
+	EsmClass             byte
+	ProtocolId           byte
+	PriorityFlag         byte
+	ScheduleDeliveryTime string
+	ValidityPeriod       string
+	RegisteredDelivery   byte
+	ReplaceIfPresent     byte
+	DataCoding           byte
+	SmDefaultMsgId       byte
+	ShortMessage         []byte
+	Tlvs                 []Tlv
+}
+
+type Bind struct {
+	SystemId         string
+	Password         string
+	SystemType       string
+	InterfaceVersion byte
+	AddrTon          byte
+	AddrNpi          byte
+	AddressRange     string
+}
+
+type QuerySm struct {
+	MessageId     string
+	SourceAddrTon byte
+	SourceAddrNpi byte
+	SourceAddr    string
+}
+
+type CancelSm struct {
+	ServiceType     string
+	MessageId       string
+	SourceAddrTon   byte
+	SourceAddrNpi   byte
+	SourceAddr      string
+	DestAddrTon     byte
+	DestAddrNpi     byte
+	DestinationAddr string
+}
+
+type ReplaceSm struct {
+	MessageId            string
+	SourceAddrTon        byte
+	SourceAddrNpi        byte
+	SourceAddr           string
+	ScheduleDeliveryTime string
+	ValidityPeriod       string
+	RegisteredDelivery   byte
+	SmDefaultMsgId       byte
+	ShortMessage         []byte
+}
+
+// destination address in submit_multi. Addr is dl_name for distribution lists
+type DestAddress struct {
+	DestFlag byte
+	Ton      byte
+	Npi      byte
+	Addr     string
+}
+
+// SubmitMulti shares all fields of SubmitSm, except destination address which is replaced by the Dests list
+type SubmitMulti struct {
+	SubmitSm
+	Dests []DestAddress
+}
+
+// dest_flag values
+const (
+	DEST_FLAG_SME = 1
+	DEST_FLAG_DL  = 2
+)
+
+// error found while decoding or validating a PDU.
+// Status is the SMPP command_status which should be returned to the ESME
+type PduError struct {
+	Status uint32
+	Reason string
+}
+
+func (e *PduError) Error() string {
+	return fmt.Sprintf("%s (status 0x%08X)", e.Reason, e.Status)
+}
+
+func pduError(status uint32, format string, args ...interface{}) *PduError {
+	return &PduError{status, fmt.Sprintf(format, args...)}
+}
+
+// pdu status for the error returned by parse/validate functions
+func pduErrorStatus(err error) uint32 {
+	if pduErr, ok := err.(*PduError); ok {
+		return pduErr.Status
+	}
+	return STS_SYS_ERROR
+}
+
+// max length (including null terminator) of the c-octet string fields
+const (
+	MAX_SYSTEM_ID_LEN    = 16
+	MAX_PASSWORD_LEN     = 9
+	MAX_SYSTEM_TYPE_LEN  = 13
+	MAX_ADDR_RANGE_LEN   = 41
+	MAX_SERVICE_TYPE_LEN = 6
+	MAX_MESSAGE_ID_LEN   = 65
+	MAX_DL_NAME_LEN      = 21
+	MAX_ADDR_LEN         = 21
+	MAX_DATA_SM_ADDR_LEN = 65
+	MAX_TIME_LEN         = 17
+	MAX_SHORT_MSG_LEN    = 254
+)
+
+// expected length of the fixed size optional parameters
+var tlvFixedLengths = map[int]int{
+	0x0005: 1, // dest_addr_subunit
+	0x0006: 1, // dest_network_type
+	0x0007: 1, // dest_bearer_type
+	0x0008: 2, // dest_telematics_id
+	0x000D: 1, // source_addr_subunit
+	0x000E: 1, // source_network_type
+	0x000F: 1, // source_bearer_type
+	0x0010: 1, // source_telematics_id
+	0x0017: 4, // qos_time_to_live
+	0x0019: 1, // payload_type
+	0x0030: 1, // ms_msg_wait_facilities
+	0x0201: 1, // privacy_indicator
+	0x0204: 2, // user_message_reference
+	0x0205: 1, // user_response_code
+	0x020A: 2, // source_port
+	0x020B: 2, // destination_port
+	0x020C: 2, // sar_msg_ref_num
+	0x020D: 1, // language_indicator
+	0x020E: 1, // sar_total_segments
+	0x020F: 1, // sar_segment_seqnum
+	0x0210: 1, // sc_interface_version
+	0x0302: 1, // callback_num_pres_ind
+	0x0304: 1, // number_of_messages
+	0x0427: 1, // message_state
+	0x1204: 1, // ms_validity
+	0x1380: 1, // its_reply_type
+	0x1383: 2, // its_session_info
+}
+
+// pduReader sequentially reads fields from the PDU body
+type pduReader struct {
+	buf []byte
+	pos int
+}
+
+func newPduReader(body []byte) *pduReader {
+	return &pduReader{body, 0}
+}
+
+func (r *pduReader) remaining() int {
+	return len(r.buf) - r.pos
+}
+
+func (r *pduReader) readByte() (byte, error) {
+	if r.remaining() < 1 {
+		return 0, fmt.Errorf("unexpected end of pdu body at offset %d", r.pos)
+	}
+	b := r.buf[r.pos]
+	r.pos++
+	return b, nil
+}
+
+func (r *pduReader) readBytes(n int) ([]byte, error) {
+	if r.remaining() < n {
+		return nil, fmt.Errorf("cannot read %d bytes at offset %d, only %d left", n, r.pos, r.remaining())
+	}
+	b := r.buf[r.pos : r.pos+n]
+	r.pos += n
+	return b, nil
+}
+
+// read null terminated string, maxLen includes null terminator
+func (r *pduReader) readCString(maxLen int) (string, error) {
+	field := r.buf[r.pos:]
+	if len(field) > maxLen {
+		field = field[:maxLen]
+	}
+	idx := bytes.IndexByte(field, 0)
+	if idx == -1 {
+		return "", fmt.Errorf("cannot find null terminator in %d bytes after offset %d", maxLen, r.pos)
+	}
+	s := string(r.buf[r.pos : r.pos+idx])
+	r.pos += idx + 1 // skip null terminator
+	return s, nil
+}
+
+func (r *pduReader) readTlvs() ([]Tlv, error) {
+	var tlvs []Tlv
+	for r.remaining() > 0 {
+		head, err := r.readBytes(4)
+		if err != nil {
+			return nil, pduError(STS_INV_TLV_STREAM, "truncated tlv header: %v", err)
+		}
+		tag := int(binary.BigEndian.Uint16(head[0:]))
+		tlvLen := int(binary.BigEndian.Uint16(head[2:]))
+		if expectedLen, ok := tlvFixedLengths[tag]; ok && expectedLen != tlvLen {
+			return nil, pduError(STS_INV_TLV_LEN, "tlv 0x%04X should have length %d, got %d", tag, expectedLen, tlvLen)
+		}
+		value, err := r.readBytes(tlvLen)
+		if err != nil {
+			return nil, pduError(STS_INV_TLV_STREAM, "truncated tlv 0x%04X: %v", tag, err)
+		}
+		tlvs = append(tlvs, Tlv{tag, tlvLen, value})
+	}
+	return tlvs, nil
+}
+
+func findTlv(tlvs []Tlv, tag int) (Tlv, bool) {
+	for _, t := range tlvs {
+		if t.Tag == tag {
+			return t, true
+		}
+	}
+	return Tlv{}, false
+}
+
+func parseBind(pduBody []byte) (*Bind, error) {
+	r := newPduReader(pduBody)
+	bind := Bind{}
+	var err error
+
+	if bind.SystemId, err = r.readCString(MAX_SYSTEM_ID_LEN); err != nil {
+		return nil, pduError(STS_INV_SYS_ID, "invalid system_id: %v", err)
+	}
+	if bind.Password, err = r.readCString(MAX_PASSWORD_LEN); err != nil {
+		return nil, pduError(STS_INV_PASSWD, "invalid password: %v", err)
+	}
+	if bind.SystemType, err = r.readCString(MAX_SYSTEM_TYPE_LEN); err != nil {
+		return nil, pduError(STS_INV_SYSTEM_TYPE, "invalid system_type: %v", err)
+	}
+	if bind.InterfaceVersion, err = r.readByte(); err != nil {
+		return nil, pduError(STS_INV_CMD_LEN, "invalid interface_version: %v", err)
+	}
+	if bind.AddrTon, err = r.readByte(); err != nil {
+		return nil, pduError(STS_INV_CMD_LEN, "invalid addr_ton: %v", err)
+	}
+	if bind.AddrNpi, err = r.readByte(); err != nil {
+		return nil, pduError(STS_INV_CMD_LEN, "invalid addr_npi: %v", err)
+	}
+	if bind.AddressRange, err = r.readCString(MAX_ADDR_RANGE_LEN); err != nil {
+		return nil, pduError(STS_INV_CMD_LEN, "invalid address_range: %v", err)
+	}
+
+	return &bind, nil
+}
+
+func parseQuerySm(pduBody []byte) (*QuerySm, error) {
+	r := newPduReader(pduBody)
+	q := QuerySm{}
+	var err error
+
+	if q.MessageId, err = r.readCString(MAX_MESSAGE_ID_LEN); err != nil {
+		return nil, pduError(STS_INV_MSG_ID, "invalid message_id: %v", err)
+	}
+	if q.SourceAddrTon, err = r.readByte(); err != nil {
+		return nil, pduError(STS_INV_CMD_LEN, "invalid source_addr_ton: %v", err)
+	}
+	if q.SourceAddrNpi, err = r.readByte(); err != nil {
+		return nil, pduError(STS_INV_CMD_LEN, "invalid source_addr_npi: %v", err)
+	}
+	if q.SourceAddr, err = r.readCString(MAX_ADDR_LEN); err != nil {
+		return nil, pduError(STS_INV_SRC_ADR, "invalid source_addr: %v", err)
+	}
+
+	return &q, nil
+}
+
+func parseCancelSm(pduBody []byte) (*CancelSm, error) {
+	r := newPduReader(pduBody)
+	c := CancelSm{}
+	var err error
+
+	if c.ServiceType, err = r.readCString(MAX_SERVICE_TYPE_LEN); err != nil {
+		return nil, pduError(STS_INV_SERVICE_TYPE, "invalid service_type: %v", err)
+	}
+	if c.MessageId, err = r.readCString(MAX_MESSAGE_ID_LEN); err != nil {
+		return nil, pduError(STS_INV_MSG_ID, "invalid message_id: %v", err)
+	}
+	if c.SourceAddrTon, err = r.readByte(); err != nil {
+		return nil, pduError(STS_INV_CMD_LEN, "invalid source_addr_ton: %v", err)
+	}
+	if c.SourceAddrNpi, err = r.readByte(); err != nil {
+		return nil, pduError(STS_INV_CMD_LEN, "invalid source_addr_npi: %v", err)
+	}
+	if c.SourceAddr, err = r.readCString(MAX_ADDR_LEN); err != nil {
+		return nil, pduError(STS_INV_SRC_ADR, "invalid source_addr: %v", err)
+	}
+	if c.DestAddrTon, err = r.readByte(); err != nil {
+		return nil, pduError(STS_INV_CMD_LEN, "invalid dest_addr_ton: %v", err)
+	}
+	if c.DestAddrNpi, err = r.readByte(); err != nil {
+		return nil, pduError(STS_INV_CMD_LEN, "invalid dest_addr_npi: %v", err)
+	}
+	if c.DestinationAddr, err = r.readCString(MAX_ADDR_LEN); err != nil {
+		return nil, pduError(STS_INV_DST_ADR, "invalid destination_addr: %v", err)
+	}
+
+	return &c, nil
+}
+
+func parseReplaceSm(pduBody []byte) (*ReplaceSm, error) {
+	r := newPduReader(pduBody)
+	rs := ReplaceSm{}
+	var err error
+
+	if rs.MessageId, err = r.readCString(MAX_MESSAGE_ID_LEN); err != nil {
+		return nil, pduError(STS_INV_MSG_ID, "invalid message_id: %v", err)
+	}
+	if rs.SourceAddrTon, err = r.readByte(); err != nil {
+		return nil, pduError(STS_INV_CMD_LEN, "invalid source_addr_ton: %v", err)
+	}
+	if rs.SourceAddrNpi, err = r.readByte(); err != nil {
+		return nil, pduError(STS_INV_CMD_LEN, "invalid source_addr_npi: %v", err)
+	}
+	if rs.SourceAddr, err = r.readCString(MAX_ADDR_LEN); err != nil {
+		return nil, pduError(STS_INV_SRC_ADR, "invalid source_addr: %v", err)
+	}
+	if rs.ScheduleDeliveryTime, err = r.readCString(MAX_TIME_LEN); err != nil {
+		return nil, pduError(STS_INV_SCHED, "invalid schedule_delivery_time: %v", err)
+	}
+	if rs.ValidityPeriod, err = r.readCString(MAX_TIME_LEN); err != nil {
+		return nil, pduError(STS_INV_EXPIRY, "invalid validity_period: %v", err)
+	}
+	if rs.RegisteredDelivery, err = r.readByte(); err != nil {
+		return nil, pduError(STS_INV_CMD_LEN, "invalid registered_delivery: %v", err)
+	}
+	if rs.SmDefaultMsgId, err = r.readByte(); err != nil {
+		return nil, pduError(STS_INV_CMD_LEN, "invalid sm_default_msg_id: %v", err)
+	}
+	smLen, err := r.readByte()
+	if err != nil {
+		return nil, pduError(STS_INV_CMD_LEN, "invalid sm_length: %v", err)
+	}
+	if smLen > MAX_SHORT_MSG_LEN {
+		return nil, pduError(STS_INV_MSG_LEN, "sm_length %d exceeds %d", smLen, MAX_SHORT_MSG_LEN)
+	}
+	if rs.ShortMessage, err = r.readBytes(int(smLen)); err != nil {
+		return nil, pduError(STS_INV_MSG_LEN, "invalid short_message: %v", err)
+	}
+
+	if !isValidSmppTime(rs.ScheduleDeliveryTime) {
+		return nil, pduError(STS_INV_SCHED, "invalid schedule_delivery_time [%s]", rs.ScheduleDeliveryTime)
+	}
+	if !isValidSmppTime(rs.ValidityPeriod) {
+		return nil, pduError(STS_INV_EXPIRY, "invalid validity_period [%s]", rs.ValidityPeriod)
+	}
+	if rs.RegisteredDelivery&0xE0 != 0 {
+		return nil, pduError(STS_INV_REG_DLV_FLG, "reserved bits are set in registered_delivery 0x%02X", rs.RegisteredDelivery)
+	}
+
+	return &rs, nil
+}
+
+func parseSubmitSm(pduBody []byte) (*SubmitSm, error) {
+	r := newPduReader(pduBody)
+	sm := SubmitSm{}
+	var err error
+
+	if sm.ServiceType, err = r.readCString(MAX_SERVICE_TYPE_LEN); err != nil {
+		return nil, pduError(STS_INV_SERVICE_TYPE, "invalid service_type: %v", err)
+	}
+	if sm.SourceAddrTon, err = r.readByte(); err != nil {
+		return nil, pduError(STS_INV_CMD_LEN, "invalid source_addr_ton: %v", err)
+	}
+	if sm.SourceAddrNpi, err = r.readByte(); err != nil {
+		return nil, pduError(STS_INV_CMD_LEN, "invalid source_addr_npi: %v", err)
+	}
+	if sm.SourceAddr, err = r.readCString(MAX_ADDR_LEN); err != nil {
+		return nil, pduError(STS_INV_SRC_ADR, "invalid source_addr: %v", err)
+	}
+	if sm.DestAddrTon, err = r.readByte(); err != nil {
+		return nil, pduError(STS_INV_CMD_LEN, "invalid dest_addr_ton: %v", err)
+	}
+	if sm.DestAddrNpi, err = r.readByte(); err != nil {
+		return nil, pduError(STS_INV_CMD_LEN, "invalid dest_addr_npi: %v", err)
+	}
+	if sm.DestinationAddr, err = r.readCString(MAX_ADDR_LEN); err != nil {
+		return nil, pduError(STS_INV_DST_ADR, "invalid destination_addr: %v", err)
+	}
+	if err = readSmFields(r, &sm); err != nil {
+		return nil, err
+	}
+
+	return &sm, nil
+}
+
+// read fields which follow destination address(es) in submit_sm and submit_multi PDUs
+func readSmFields(r *pduReader, sm *SubmitSm) error {
+	var err error
+
+	if sm.EsmClass, err = r.readByte(); err != nil {
+		return pduError(STS_INV_CMD_LEN, "invalid esm_class: %v", err)
+	}
+	if sm.ProtocolId, err = r.readByte(); err != nil {
+		return pduError(STS_INV_CMD_LEN, "invalid protocol_id: %v", err)
+	}
+	if sm.PriorityFlag, err = r.readByte(); err != nil {
+		return pduError(STS_INV_CMD_LEN, "invalid priority_flag: %v", err)
+	}
+	if sm.ScheduleDeliveryTime, err = r.readCString(MAX_TIME_LEN); err != nil {
+		return pduError(STS_INV_SCHED, "invalid schedule_delivery_time: %v", err)
+	}
+	if sm.ValidityPeriod, err = r.readCString(MAX_TIME_LEN); err != nil {
+		return pduError(STS_INV_EXPIRY, "invalid validity_period: %v", err)
+	}
+	if sm.RegisteredDelivery, err = r.readByte(); err != nil {
+		return pduError(STS_INV_CMD_LEN, "invalid registered_delivery: %v", err)
+	}
+	if sm.ReplaceIfPresent, err = r.readByte(); err != nil {
+		return pduError(STS_INV_CMD_LEN, "invalid replace_if_present_flag: %v", err)
+	}
+	if sm.DataCoding, err = r.readByte(); err != nil {
+		return pduError(STS_INV_CMD_LEN, "invalid data_coding: %v", err)
+	}
+	if sm.SmDefaultMsgId, err = r.readByte(); err != nil {
+		return pduError(STS_INV_CMD_LEN, "invalid sm_default_msg_id: %v", err)
+	}
+	smLen, err := r.readByte()
+	if err != nil {
+		return pduError(STS_INV_CMD_LEN, "invalid sm_length: %v", err)
+	}
+	if smLen > MAX_SHORT_MSG_LEN {
+		return pduError(STS_INV_MSG_LEN, "sm_length %d exceeds %d", smLen, MAX_SHORT_MSG_LEN)
+	}
+	if sm.ShortMessage, err = r.readBytes(int(smLen)); err != nil {
+		return pduError(STS_INV_MSG_LEN, "invalid short_message: %v", err)
+	}
+	if sm.Tlvs, err = r.readTlvs(); err != nil {
+		return err
+	}
+	// long message could be sent in message_payload TLV with empty short_message
+	if payload, ok := findTlv(sm.Tlvs, TLV_MESSAGE_PAYLOAD); ok {
+		if smLen != 0 {
+			return pduError(STS_INV_TLV_VAL, "both short_message and message_payload are set")
+		}
+		sm.ShortMessage = payload.Value
+	}
+	return nil
+}
+
+// decode data_sm into SubmitSm. Content of the message_payload TLV is used as short message
+func parseDataSm(pduBody []byte) (*SubmitSm, error) {
+	r := newPduReader(pduBody)
+	sm := SubmitSm{}
+	var err error
+
+	if sm.ServiceType, err = r.readCString(MAX_SERVICE_TYPE_LEN); err != nil {
+		return nil, pduError(STS_INV_SERVICE_TYPE, "invalid service_type: %v", err)
+	}
+	if sm.SourceAddrTon, err = r.readByte(); err != nil {
+		return nil, pduError(STS_INV_CMD_LEN, "invalid source_addr_ton: %v", err)
+	}
+	if sm.SourceAddrNpi, err = r.readByte(); err != nil {
+		return nil, pduError(STS_INV_CMD_LEN, "invalid source_addr_npi: %v", err)
+	}
+	if sm.SourceAddr, err = r.readCString(MAX_DATA_SM_ADDR_LEN); err != nil {
+		return nil, pduError(STS_INV_SRC_ADR, "invalid source_addr: %v", err)
+	}
+	if sm.DestAddrTon, err = r.readByte(); err != nil {
+		return nil, pduError(STS_INV_CMD_LEN, "invalid dest_addr_ton: %v", err)
+	}
+	if sm.DestAddrNpi, err = r.readByte(); err != nil {
+		return nil, pduError(STS_INV_CMD_LEN, "invalid dest_addr_npi: %v", err)
+	}
+	if sm.DestinationAddr, err = r.readCString(MAX_DATA_SM_ADDR_LEN); err != nil {
+		return nil, pduError(STS_INV_DST_ADR, "invalid destination_addr: %v", err)
+	}
+	if sm.EsmClass, err = r.readByte(); err != nil {
+		return nil, pduError(STS_INV_CMD_LEN, "invalid esm_class: %v", err)
+	}
+	if sm.RegisteredDelivery, err = r.readByte(); err != nil {
+		return nil, pduError(STS_INV_CMD_LEN, "invalid registered_delivery: %v", err)
+	}
+	if sm.DataCoding, err = r.readByte(); err != nil {
+		return nil, pduError(STS_INV_CMD_LEN, "invalid data_coding: %v", err)
+	}
+	if sm.Tlvs, err = r.readTlvs(); err != nil {
+		return nil, err
+	}
+	if payload, ok := findTlv(sm.Tlvs, TLV_MESSAGE_PAYLOAD); ok {
+		sm.ShortMessage = payload.Value
+	}
+
+	return &sm, nil
+}
+
+func parseSubmitMulti(pduBody []byte) (*SubmitMulti, error) {
+	r := newPduReader(pduBody)
+	sm := SubmitMulti{}
+	var err error
+
+	if sm.ServiceType, err = r.readCString(MAX_SERVICE_TYPE_LEN); err != nil {
+		return nil, pduError(STS_INV_SERVICE_TYPE, "invalid service_type: %v", err)
+	}
+	if sm.SourceAddrTon, err = r.readByte(); err != nil {
+		return nil, pduError(STS_INV_CMD_LEN, "invalid source_addr_ton: %v", err)
+	}
+	if sm.SourceAddrNpi, err = r.readByte(); err != nil {
+		return nil, pduError(STS_INV_CMD_LEN, "invalid source_addr_npi: %v", err)
+	}
+	if sm.SourceAddr, err = r.readCString(MAX_ADDR_LEN); err != nil {
+		return nil, pduError(STS_INV_SRC_ADR, "invalid source_addr: %v", err)
+	}
+	numberOfDests, err := r.readByte()
+	if err != nil {
+		return nil, pduError(STS_INV_CMD_LEN, "invalid number_of_dests: %v", err)
+	}
+	if numberOfDests == 0 {
+		return nil, pduError(STS_INV_NUM_DESTS, "number_of_dests is zero")
+	}
+	for i := 0; i < int(numberOfDests); i++ {
+		dest := DestAddress{}
+		if dest.DestFlag, err = r.readByte(); err != nil {
+			return nil, pduError(STS_INV_CMD_LEN, "invalid dest_flag: %v", err)
+		}
+		switch dest.DestFlag {
+		case DEST_FLAG_SME:
+			if dest.Ton, err = r.readByte(); err != nil {
+				return nil, pduError(STS_INV_CMD_LEN, "invalid dest_addr_ton: %v", err)
+			}
+			if dest.Npi, err = r.readByte(); err != nil {
+				return nil, pduError(STS_INV_CMD_LEN, "invalid dest_addr_npi: %v", err)
+			}
+			if dest.Addr, err = r.readCString(MAX_ADDR_LEN); err != nil {
+				return nil, pduError(STS_INV_DST_ADR, "invalid destination_addr: %v", err)
+			}
+		case DEST_FLAG_DL:
+			if dest.Addr, err = r.readCString(MAX_DL_NAME_LEN); err != nil {
+				return nil, pduError(STS_INV_DL_NAME, "invalid dl_name: %v", err)
+			}
+		default:
+			return nil, pduError(STS_INV_DEST_FLAG, "invalid dest_flag %d", dest.DestFlag)
+		}
+		sm.Dests = append(sm.Dests, dest)
+	}
+	if err = readSmFields(r, &sm.SubmitSm); err != nil {
+		return nil, err
+	}
+
+	return &sm, nil
+}
+
+// check mandatory fields of the decoded submit_sm.
+// Returns *PduError with appropriate command_status
+func validateSubmitSm(sm *SubmitSm) error {
+	if sm.DestinationAddr == "" {
+		return pduError(STS_INV_DST_ADR, "empty destination_addr")
+	}
+	return validateSmFields(sm)
+}
+
+// check fields shared by submit_sm and submit_multi
+func validateSmFields(sm *SubmitSm) error {
+	// message type bits (2-5) of esm_class. Only default message type
+	// and SME acknowledgements could be submitted by ESME
+	msgType := sm.EsmClass & 0x3C
+	if msgType != 0x00 && msgType != 0x08 && msgType != 0x10 {
+		return pduError(STS_INV_ESM_CLASS, "invalid message type in esm_class 0x%02X", sm.EsmClass)
+	}
+	if sm.PriorityFlag > 3 {
+		return pduError(STS_INV_PRT_FLG, "invalid priority_flag %d", sm.PriorityFlag)
+	}
+	now := time.Now()
+	if err := validateSmTimes(sm.ScheduleDeliveryTime, sm.ValidityPeriod, now, now); err != nil {
+		return err
+	}
+	if sm.RegisteredDelivery&0xE0 != 0 {
+		return pduError(STS_INV_REG_DLV_FLG, "reserved bits are set in registered_delivery 0x%02X", sm.RegisteredDelivery)
+	}
+	if sm.ReplaceIfPresent > 1 {
+		return pduError(STS_INV_REP_FLAG, "invalid replace_if_present_flag %d", sm.ReplaceIfPresent)
+	}
+	if !isValidDataCoding(sm.DataCoding) {
+		return pduError(STS_INV_DCS, "reserved data_coding 0x%02X", sm.DataCoding)
+	}
+	return nil
+}
+
+// check schedule_delivery_time and validity_period, relative times are counted from base.
+// Validity period should not be in the past or before schedule_delivery_time
+func validateSmTimes(scheduleDeliveryTime, validityPeriod string, base, now time.Time) error {
+	schedule, err := parseSmppTime(scheduleDeliveryTime, base)
+	if err != nil {
+		return pduError(STS_INV_SCHED, "invalid schedule_delivery_time [%s]", scheduleDeliveryTime)
+	}
+	expiry, err := parseSmppTime(validityPeriod, base)
+	if err != nil {
+		return pduError(STS_INV_EXPIRY, "invalid validity_period [%s]", validityPeriod)
+	}
+	if !expiry.IsZero() && (expiry.Before(now) || expiry.Before(schedule)) {
+		return pduError(STS_INV_EXPIRY, "validity_period [%s] is in the past or before schedule_delivery_time", validityPeriod)
+	}
+	return nil
+}
+
+func isValidDataCoding(dc byte) bool {
+	// 0x0B-0x0C and 0x0F-0xBF are reserved by SMPP 3.4
+	return !(dc == 0x0B || dc == 0x0C || (dc >= 0x0F && dc <= 0xBF))
+}
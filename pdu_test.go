@@ -0,0 +1,163 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseSubmitSm(t *testing.T) {
+	pduBody := []byte{
+		0x43, 0x4d, 0x54, 0x00, // service_type
+		0x01, 0x01, 0x37, 0x37, 0x30, 0x31, 0x00, // source_addr_ton, source_addr_npi, source_addr
+		0x02, 0x03, 0x31, 0x30, 0x30, 0x31, 0x00, // dest_addr_ton, dest_addr_npi, destination_addr
+		0x40, // esm class
+		0x07, // protocol_id
+		0x01, // priority_flag
+		// schedule_delivery_time
+		0x30, 0x30, 0x30, 0x30, 0x30, 0x30, 0x30, 0x30, 0x30, 0x31, 0x30, 0x30, 0x30, 0x30, 0x30, 0x52, 0x00,
+		0x00,                   // validity_period
+		0x01,                   // registered_delivery
+		0x00,                   // replace_if_present_flag
+		0x08,                   // data_coding
+		0x00,                   // sm_default_msg_id
+		0x04,                   // sm_length
+		0x00, 0x54, 0x00, 0x65, // short_message
+		0x02, 0x0C, 0x00, 0x02, 0x00, 0x2A, // sar_msg_ref_num tlv
+	}
+
+	expected := &SubmitSm{
+		ServiceType:          "CMT",
+		SourceAddrTon:        1,
+		SourceAddrNpi:        1,
+		SourceAddr:           "7701",
+		DestAddrTon:          2,
+		DestAddrNpi:          3,
+		DestinationAddr:      "1001",
+		EsmClass:             0x40,
+		ProtocolId:           7,
+		PriorityFlag:         1,
+		ScheduleDeliveryTime: "000000000100000R",
+		ValidityPeriod:       "",
+		RegisteredDelivery:   1,
+		ReplaceIfPresent:     0,
+		DataCoding:           CODING_UCS2,
+		SmDefaultMsgId:       0,
+		ShortMessage:         []byte{0x00, 0x54, 0x00, 0x65},
+		Tlvs:                 []Tlv{{0x020C, 2, []byte{0x00, 0x2A}}},
+	}
+
+	actual, err := parseSubmitSm(pduBody)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if !reflect.DeepEqual(expected, actual) {
+		t.Errorf("submit_sm incorrectly decoded\nexpected: %+v\nactual: %+v", expected, actual)
+	}
+}
+
+func TestParseSubmitSmWithMessagePayload(t *testing.T) {
+	pduBody := []byte{
+		0x00,                         // service_type
+		0x00, 0x00, 0x37, 0x37, 0x00, // source_addr_ton, source_addr_npi, source_addr
+		0x00, 0x00, 0x31, 0x00, // dest_addr_ton, dest_addr_npi, destination_addr
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		0x00,                                     // sm_length
+		0x04, 0x24, 0x00, 0x03, 0x61, 0x62, 0x63, // message_payload tlv
+	}
+	sm, err := parseSubmitSm(pduBody)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if string(sm.ShortMessage) != "abc" {
+		t.Errorf("expected short message from message_payload, got %v", sm.ShortMessage)
+	}
+
+	// both short_message and message_payload
+	pduBody[19] = 0x01
+	pduBody = append(pduBody[:20], append([]byte{0x61}, pduBody[20:]...)...)
+	if _, err := parseSubmitSm(pduBody); pduErrorStatus(err) != STS_INV_TLV_VAL {
+		t.Errorf("expected status 0x%08X, got %v", STS_INV_TLV_VAL, err)
+	}
+}
+
+func TestParseTruncatedSubmitSm(t *testing.T) {
+	pduBody := []byte{
+		0x00,                         // service_type
+		0x00, 0x00, 0x37, 0x37, 0x00, // source_addr_ton, source_addr_npi, source_addr
+		0x00, 0x00, 0x31, 0x00, // dest_addr_ton, dest_addr_npi, destination_addr
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		0x0A, // sm_length is bigger than the rest of the body
+		0x54, 0x65,
+	}
+	if _, err := parseSubmitSm(pduBody); err == nil {
+		t.Errorf("truncated submit_sm should not be decoded")
+	}
+}
+
+func TestParseSubmitSmErrorStatus(t *testing.T) {
+	longAddr := []byte("7701234567890123456789") // 22 chars, max is 20
+	pduBody := append([]byte{0x00, 0x00, 0x00}, longAddr...)
+	pduBody = append(pduBody, 0x00)
+
+	_, err := parseSubmitSm(pduBody)
+	if status := pduErrorStatus(err); status != STS_INV_SRC_ADR {
+		t.Errorf("expected status 0x%08X, got 0x%08X (%v)", STS_INV_SRC_ADR, status, err)
+	}
+}
+
+func TestParseInvalidTlvLength(t *testing.T) {
+	r := newPduReader([]byte{0x02, 0x0E, 0x00, 0x02, 0x01, 0x01}) // sar_total_segments with length 2
+	_, err := r.readTlvs()
+	if status := pduErrorStatus(err); status != STS_INV_TLV_LEN {
+		t.Errorf("expected status 0x%08X, got 0x%08X (%v)", STS_INV_TLV_LEN, status, err)
+	}
+}
+
+func TestValidateSubmitSm(t *testing.T) {
+	valid := SubmitSm{DestinationAddr: "1001", ValidityPeriod: "000001000000000R"}
+	if err := validateSubmitSm(&valid); err != nil {
+		t.Errorf("unexpected error %v", err)
+	}
+
+	invalid := map[uint32]SubmitSm{
+		STS_INV_DST_ADR:     {},
+		STS_INV_ESM_CLASS:   {DestinationAddr: "1001", EsmClass: 0x04},
+		STS_INV_PRT_FLG:     {DestinationAddr: "1001", PriorityFlag: 4},
+		STS_INV_SCHED:       {DestinationAddr: "1001", ScheduleDeliveryTime: "2101011200"},
+		STS_INV_EXPIRY:      {DestinationAddr: "1001", ValidityPeriod: "210101120000000X"},
+		STS_INV_REG_DLV_FLG: {DestinationAddr: "1001", RegisteredDelivery: 0x20},
+		STS_INV_REP_FLAG:    {DestinationAddr: "1001", ReplaceIfPresent: 2},
+		STS_INV_DCS:         {DestinationAddr: "1001", DataCoding: 0x0B},
+	}
+	for expectedStatus, sm := range invalid {
+		err := validateSubmitSm(&sm)
+		if status := pduErrorStatus(err); status != expectedStatus {
+			t.Errorf("expected status 0x%08X, got 0x%08X (%v)", expectedStatus, status, err)
+		}
+	}
+}
+
+func TestParseSubmitMulti(t *testing.T) {
+	pduBody := []byte{
+		0x00,                         // service_type
+		0x00, 0x00, 0x37, 0x37, 0x00, // source_addr_ton, source_addr_npi, source_addr
+		0x02,                               // number_of_dests
+		0x01, 0x01, 0x01, 0x31, 0x30, 0x00, // sme dest_address
+		0x02, 0x64, 0x6c, 0x00, // distribution list dest_address
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00,
+		0x02,       // sm_length
+		0x48, 0x69, // short_message
+	}
+
+	sm, err := parseSubmitMulti(pduBody)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	expectedDests := []DestAddress{{DEST_FLAG_SME, 1, 1, "10"}, {DEST_FLAG_DL, 0, 0, "dl"}}
+	if !reflect.DeepEqual(expectedDests, sm.Dests) {
+		t.Errorf("expected dests %+v, got %+v", expectedDests, sm.Dests)
+	}
+	if sm.SourceAddr != "77" || sm.RegisteredDelivery != 1 || string(sm.ShortMessage) != "Hi" {
+		t.Errorf("submit_multi incorrectly decoded %+v", sm)
+	}
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Pattern matches a PDU field. It is either an exact value, a prefix ended
+// with '*' or a regular expression started with '~'. Empty pattern matches any value
+type Pattern struct {
+	def string
+	re  *regexp.Regexp
+}
+
+func parsePattern(def string) (Pattern, error) {
+	if strings.HasPrefix(def, "~") {
+		re, err := regexp.Compile(def[1:])
+		if err != nil {
+			return Pattern{}, fmt.Errorf("invalid regexp [%s]: %v", def[1:], err)
+		}
+		return Pattern{def, re}, nil
+	}
+	return Pattern{def, nil}, nil
+}
+
+func (p Pattern) Match(value string) bool {
+	if p.def == "" {
+		return true
+	}
+	if p.re != nil {
+		return p.re.MatchString(value)
+	}
+	return matchAddr(p.def, value)
+}
+
+// Route decides how messages matching all of its patterns are handled
+type Route struct {
+	Dest        Pattern
+	Source      Pattern
+	SystemId    Pattern
+	ServiceType Pattern
+	Status      uint32       // command_status of the submit response, messages are rejected if not STS_OK
+	Outcomes    []DlrOutcome // overrides outcomes of the default DLR policy if not empty
+	Delay       *DlrDelay    // overrides delay of the default DLR policy if not nil
+}
+
+func (route *Route) Match(systemId string, sm *SubmitSm) bool {
+	return route.Dest.Match(sm.DestinationAddr) &&
+		route.Source.Match(sm.SourceAddr) &&
+		route.SystemId.Match(systemId) &&
+		route.ServiceType.Match(sm.ServiceType)
+}
+
+// Routes are checked in order, first matching route wins
+type Routes []Route
+
+// returns nil if no route matches the message
+func (routes Routes) Find(systemId string, sm *SubmitSm) *Route {
+	for i := range routes {
+		if routes[i].Match(systemId, sm) {
+			return &routes[i]
+		}
+	}
+	return nil
+}
+
+// DLR policy for messages of the route
+func (smsc *Smsc) dlrPolicy(route *Route) DlrPolicy {
+	policy := smsc.Dlr
+	if route == nil {
+		return policy
+	}
+	if len(route.Outcomes) > 0 {
+		policy.Outcomes = route.Outcomes
+	}
+	if route.Delay != nil {
+		policy.Delay = *route.Delay
+	}
+	return policy
+}
+
+// parse route definition, a whitespace separated list of key=value pairs, e.g.
+// "dest=+7700* dlr=UNDELIV:069 delay=500ms". Keys dest, src, system_id and service_type
+// define patterns, keys status, dlr and delay define behaviour of the route
+func parseRoute(def string) (Route, error) {
+	var route Route
+	for _, field := range strings.Fields(def) {
+		idx := strings.Index(field, "=")
+		if idx < 1 {
+			return Route{}, fmt.Errorf("invalid field [%s] in route [%s]", field, def)
+		}
+		key, value := field[:idx], field[idx+1:]
+		var err error
+		switch key {
+		case "dest":
+			route.Dest, err = parsePattern(value)
+		case "src":
+			route.Source, err = parsePattern(value)
+		case "system_id":
+			route.SystemId, err = parsePattern(value)
+		case "service_type":
+			route.ServiceType, err = parsePattern(value)
+		case "status":
+			var sts uint64
+			sts, err = strconv.ParseUint(value, 0, 32)
+			route.Status = uint32(sts)
+		case "dlr":
+			route.Outcomes, err = parseDlrOutcomes(value)
+		case "delay":
+			var delay DlrDelay
+			delay, err = parseDlrDelay(value)
+			route.Delay = &delay
+		default:
+			err = fmt.Errorf("unknown key")
+		}
+		if err != nil {
+			return Route{}, fmt.Errorf("invalid field [%s] in route [%s]: %v", field, def, err)
+		}
+	}
+	return route, nil
+}
+
+// read routes file with one route per line. Empty lines and lines started with # are ignored
+func readRoutes(r io.Reader) (Routes, error) {
+	var routes Routes
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		route, err := parseRoute(line)
+		if err != nil {
+			return nil, err
+		}
+		routes = append(routes, route)
+	}
+	return routes, scanner.Err()
+}
+
+// load routes from file and from semicolon separated list of definitions.
+// Routes from the list are checked before routes from the file
+func LoadRoutes(path, defs string) (Routes, error) {
+	var routes Routes
+	for _, def := range strings.Split(defs, ";") {
+		if strings.TrimSpace(def) == "" {
+			continue
+		}
+		route, err := parseRoute(def)
+		if err != nil {
+			return nil, err
+		}
+		routes = append(routes, route)
+	}
+	if path != "" {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		fileRoutes, err := readRoutes(f)
+		if err != nil {
+			return nil, fmt.Errorf("cannot read routes file %s: %v", path, err)
+		}
+		routes = append(routes, fileRoutes...)
+	}
+	return routes, nil
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseRoute(t *testing.T) {
+	route, err := parseRoute("dest=+7700* system_id=client1 dlr=UNDELIV:069 delay=500ms")
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if route.Status != STS_OK || route.Delay == nil || *route.Delay != (DlrDelay{DELAY_FIXED, 500 * time.Millisecond, 0}) {
+		t.Errorf("unexpected route %+v", route)
+	}
+	if len(route.Outcomes) != 1 || route.Outcomes[0] != (DlrOutcome{STATE_UNDELIVERABLE, 69, 100}) {
+		t.Errorf("unexpected outcomes %+v", route.Outcomes)
+	}
+
+	for _, def := range []string{"dest", "dest=~[", "status=abc", "unknown=1"} {
+		if _, err := parseRoute(def); err == nil {
+			t.Errorf("route [%s] should not be parsed", def)
+		}
+	}
+}
+
+func TestFindRoute(t *testing.T) {
+	routes, err := readRoutes(strings.NewReader(`
+# magic numbers
+dest=+7700* status=0x45
+dest=~^\+7701[0-9]{3}$ service_type=CMT dlr=EXPIRED
+system_id=client2 dlr=REJECTD
+`))
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	cases := []struct {
+		systemId string
+		sm       SubmitSm
+		route    int // index of the matching route, -1 if none
+	}{
+		{"client1", SubmitSm{DestinationAddr: "+7700123"}, 0},
+		{"client2", SubmitSm{DestinationAddr: "+7700123"}, 0},
+		{"client1", SubmitSm{DestinationAddr: "+7701123", ServiceType: "CMT"}, 1},
+		{"client1", SubmitSm{DestinationAddr: "+7701123"}, -1},
+		{"client1", SubmitSm{DestinationAddr: "+77011234", ServiceType: "CMT"}, -1},
+		{"client2", SubmitSm{DestinationAddr: "+7701123"}, 2},
+	}
+	for _, c := range cases {
+		route := routes.Find(c.systemId, &c.sm)
+		found := -1
+		for i := range routes {
+			if route == &routes[i] {
+				found = i
+			}
+		}
+		if found != c.route {
+			t.Errorf("message %+v from %s: expected route %d, got %d", c.sm, c.systemId, c.route, found)
+		}
+	}
+
+	smsc := NewSmsc(false)
+	policy := smsc.dlrPolicy(&routes[1])
+	if policy.Delay != smsc.Dlr.Delay || policy.Outcomes[0].State != STATE_EXPIRED {
+		t.Errorf("unexpected policy %+v", policy)
+	}
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math/rand"
+	"net"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// smppConn serialises writes of the PDUs, since connection handler, delivery
+// receipt timers, enquire_link loop and web handlers write to the same connection
+type smppConn struct {
+	net.Conn
+	writeMu sync.Mutex
+	stats   *SessionStats
+}
+
+func (c *smppConn) Write(pdu []byte) (int, error) {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	if c.stats != nil {
+		c.stats.countOut(pdu)
+	}
+	return c.Conn.Write(pdu)
+}
+
+// Session is a bound smpp connection. Session is immutable, rebind
+// on the same connection registers a new Session with the same id
+type Session struct {
+	Id         int
+	SystemId   string
+	ReceiveMo  bool
+	BindType   string // TRANSMITTER, RECEIVER or TRANSCEIVER
+	RemoteAddr string
+	BoundSince time.Time
+	Stats      *SessionStats // counters of the connection, shared with sessions of previous binds
+	conn       net.Conn
+	closed     <-chan struct{} // closed when connection handler exits
+	unbindSent *int32          // set when unbind is sent, so that handler expects unbind_resp
+	concatRefs *concatRefs
+}
+
+func (session *Session) Write(pdu []byte) error {
+	_, err := session.conn.Write(pdu)
+	return err
+}
+
+func bindTypeName(bindCmdId uint32) string {
+	switch bindCmdId {
+	case BIND_RECEIVER:
+		return "RECEIVER"
+	case BIND_TRANSMITTER:
+		return "TRANSMITTER"
+	default:
+		return "TRANSCEIVER"
+	}
+}
+
+// concatenation reference numbers of the long MO messages sent to the session.
+// References start from random value, so different sessions use different references
+type concatRefs struct {
+	mu   sync.Mutex
+	next uint16
+}
+
+func newConcatRefs() *concatRefs {
+	return &concatRefs{next: uint16(rand.Intn(0x10000))}
+}
+
+func (refs *concatRefs) Next() uint16 {
+	refs.mu.Lock()
+	defer refs.mu.Unlock()
+	ref := refs.next
+	refs.next++
+	return ref
+}
+
+// SessionRegistry keeps bound sessions. All methods are safe for concurrent use
+type SessionRegistry struct {
+	mu       sync.RWMutex
+	sessions map[int]*Session
+	lastId   int
+}
+
+func NewSessionRegistry() *SessionRegistry {
+	return &SessionRegistry{sessions: make(map[int]*Session)}
+}
+
+// id for the new connection
+func (registry *SessionRegistry) nextId() int {
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+	registry.lastId++
+	return registry.lastId
+}
+
+func (registry *SessionRegistry) Add(session *Session) {
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+	registry.sessions[session.Id] = session
+}
+
+func (registry *SessionRegistry) Remove(sessionId int) {
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+	delete(registry.sessions, sessionId)
+}
+
+func (registry *SessionRegistry) Get(sessionId int) (*Session, bool) {
+	registry.mu.RLock()
+	defer registry.mu.RUnlock()
+	session, ok := registry.sessions[sessionId]
+	return session, ok
+}
+
+// bound sessions ordered by id
+func (registry *SessionRegistry) List() []*Session {
+	registry.mu.RLock()
+	sessions := make([]*Session, 0, len(registry.sessions))
+	for _, session := range registry.sessions {
+		sessions = append(sessions, session)
+	}
+	registry.mu.RUnlock()
+
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].Id < sessions[j].Id
+	})
+	return sessions
+}
+
+func (registry *SessionRegistry) FindBySystemId(systemId string) []*Session {
+	var found []*Session
+	for _, session := range registry.List() {
+		if session.SystemId == systemId {
+			found = append(found, session)
+		}
+	}
+	return found
+}
+
+func (smsc *Smsc) BoundSessions() []*Session {
+	return smsc.Sessions.List()
+}
+
+// send unbind to the session. If wait is true, method blocks until
+// session is closed after unbind_resp or response timeout is elapsed
+func (smsc *Smsc) UnbindSession(sessionId int, wait bool) error {
+	session, ok := smsc.Sessions.Get(sessionId)
+	if !ok {
+		return fmt.Errorf("No session found for id: [%d]", sessionId)
+	}
+
+	if session.unbindSent != nil {
+		atomic.StoreInt32(session.unbindSent, 1)
+	}
+	if err := session.Write(headerPDU(UNBIND, STS_OK, uint32(rand.Int31()))); err != nil {
+		log.Printf("Cannot send unbind to systemId: [%s]. Network error [%v]", session.SystemId, err)
+		return fmt.Errorf("Cannot send unbind. Network error")
+	}
+	log.Printf("unbind was sent to system_id[%s]", session.SystemId)
+	if !wait {
+		return nil
+	}
+
+	select {
+	case <-session.closed:
+		return nil
+	case <-time.After(smsc.Timers.ResponseTimeout):
+		log.Printf("no unbind_resp from system_id[%s] in %v", session.SystemId, smsc.Timers.ResponseTimeout)
+		return fmt.Errorf("No unbind_resp received in %v", smsc.Timers.ResponseTimeout)
+	}
+}
+
+// abruptly close tcp connection of the session
+func (smsc *Smsc) CloseSession(sessionId int) error {
+	session, ok := smsc.Sessions.Get(sessionId)
+	if !ok {
+		return fmt.Errorf("No session found for id: [%d]", sessionId)
+	}
+	log.Printf("closing connection of system_id[%s]", session.SystemId)
+	return session.conn.Close()
+}
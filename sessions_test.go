@@ -0,0 +1,45 @@
+package main
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestUnbindSession(t *testing.T) {
+	smsc := NewSmsc(false)
+	client, server := net.Pipe()
+	go handleSmppConnection(smsc, server)
+
+	client.Write(bindTransceiverPDU("client1"))
+	readPduHeader(t, client) // bind_transceiver_resp
+
+	sessions := smsc.BoundSessions()
+	if len(sessions) != 1 || sessions[0].SystemId != "client1" {
+		t.Fatalf("expected one bound session, got %+v", sessions)
+	}
+
+	result := make(chan error)
+	go func() {
+		result <- smsc.UnbindSession(sessions[0].Id, true)
+	}()
+
+	cmdId, seqNum := readPduHeader(t, client)
+	if cmdId != UNBIND {
+		t.Fatalf("expected unbind, got 0x%08X", cmdId)
+	}
+	client.Write(headerPDU(UNBIND_RESP, STS_OK, seqNum))
+
+	select {
+	case err := <-result:
+		if err != nil {
+			t.Errorf("unexpected error %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Errorf("session was not unbound")
+	}
+	if _, err := client.Read(make([]byte, 1)); err != io.EOF {
+		t.Errorf("expected connection to be closed, got %v", err)
+	}
+}
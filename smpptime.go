@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// check that the string is empty or valid SMPP time
+func isValidSmppTime(s string) bool {
+	_, err := parseSmppTime(s, time.Now())
+	return err == nil
+}
+
+// parse SMPP time "YYMMDDhhmmsstnnp", where p is '+' or '-' for absolute time
+// (nn is a UTC offset in quarter hours) and 'R' for relative time, which is added to now.
+// Empty string is parsed as zero time
+func parseSmppTime(s string, now time.Time) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	if len(s) != 16 {
+		return time.Time{}, fmt.Errorf("invalid length of smpp time [%s]", s)
+	}
+	for i := 0; i < 15; i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return time.Time{}, fmt.Errorf("invalid smpp time [%s]", s)
+		}
+	}
+	var f [8]int // YY MM DD hh mm ss t nn
+	for i := range f {
+		width := 2
+		if i == 6 {
+			width = 1
+		}
+		pos := i * 2
+		if i > 6 {
+			pos = 13
+		}
+		f[i], _ = strconv.Atoi(s[pos : pos+width])
+	}
+
+	switch s[15] {
+	case 'R':
+		return now.AddDate(f[0], f[1], f[2]).Add(time.Duration(f[3])*time.Hour +
+			time.Duration(f[4])*time.Minute + time.Duration(f[5])*time.Second), nil
+	case '+', '-':
+		if f[7] > 48 {
+			return time.Time{}, fmt.Errorf("invalid utc offset in smpp time [%s]", s)
+		}
+		offset := f[7] * 15 * 60
+		if s[15] == '-' {
+			offset = -offset
+		}
+		loc := time.FixedZone("", offset)
+		t := time.Date(2000+f[0], time.Month(f[1]), f[2], f[3], f[4], f[5], f[6]*int(100*time.Millisecond), loc)
+		if t.Month() != time.Month(f[1]) || t.Day() != f[2] || t.Hour() != f[3] || t.Minute() != f[4] || t.Second() != f[5] {
+			return time.Time{}, fmt.Errorf("invalid date in smpp time [%s]", s)
+		}
+		return t, nil
+	default:
+		return time.Time{}, fmt.Errorf("invalid smpp time [%s]", s)
+	}
+}
+
+// format time in SMPP absolute time format "YYMMDDhhmmsstnnp"
+func formatSmppTime(t time.Time) string {
+	tenths := t.Nanosecond() / int(100*time.Millisecond)
+	_, offset := t.Zone()
+	sign := '+'
+	if offset < 0 {
+		sign = '-'
+		offset = -offset
+	}
+	quarters := offset / (15 * 60)
+	return fmt.Sprintf("%s%d%02d%c", t.Format("060102150405"), tenths, quarters, sign)
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseSmppTime(t *testing.T) {
+	now := time.Date(2021, 1, 31, 12, 0, 0, 0, time.UTC)
+	cases := []struct {
+		s        string
+		expected time.Time
+	}{
+		{"", time.Time{}},
+		{"210215103000504+", time.Date(2021, 2, 15, 9, 30, 0, 500*int(time.Millisecond), time.UTC)},
+		{"210215103000008-", time.Date(2021, 2, 15, 12, 30, 0, 0, time.UTC)},
+		{"000001020304000R", time.Date(2021, 2, 1, 14, 3, 4, 0, time.UTC)},
+	}
+	for _, c := range cases {
+		parsed, err := parseSmppTime(c.s, now)
+		if err != nil {
+			t.Errorf("time [%s]: unexpected error %v", c.s, err)
+		} else if !parsed.Equal(c.expected) {
+			t.Errorf("time [%s]: expected %v, got %v", c.s, c.expected, parsed)
+		}
+	}
+
+	for _, s := range []string{"2102151030", "210230103000000+", "210215103000049+", "21021510300000XR", "210215103000000X",
+		"21+215103000000+", "000001+10000000R", "0000010203040+1R"} {
+		if _, err := parseSmppTime(s, now); err == nil {
+			t.Errorf("time [%s] should not be parsed", s)
+		}
+	}
+
+	formatted := formatSmppTime(now.Add(100 * time.Millisecond))
+	if parsed, _ := parseSmppTime(formatted, now); !parsed.Equal(now.Add(100 * time.Millisecond)) {
+		t.Errorf("formatted time [%s] was parsed as %v", formatted, parsed)
+	}
+}
@@ -6,61 +6,109 @@ import (
 	"fmt"
 	"io"
 	"log"
-	"math"
 	"math/rand"
 	"net"
 	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
-	"unicode/utf8"
+	"unicode/utf16"
 )
 
 // command id
 const (
-	GENERIC_NACK      = 0x80000000
-	BIND_RECEIVER     = 0x00000001
-	BIND_TRANSMITTER  = 0x00000002
-	BIND_TRANSCEIVER  = 0x00000009
-	SUBMIT_SM         = 0x00000004
-	SUBMIT_SM_RESP    = 0x80000004
-	DELIVER_SM        = 0x00000005
-	DELIVER_SM_RESP   = 0x80000005
-	UNBIND            = 0x00000006
-	UNBIND_RESP       = 0x80000006
-	ENQUIRE_LINK      = 0x00000015
-	ENQUIRE_LINK_RESP = 0x80000015
+	GENERIC_NACK          = 0x80000000
+	BIND_RECEIVER         = 0x00000001
+	BIND_RECEIVER_RESP    = 0x80000001
+	BIND_TRANSMITTER      = 0x00000002
+	BIND_TRANSMITTER_RESP = 0x80000002
+	BIND_TRANSCEIVER      = 0x00000009
+	BIND_TRANSCEIVER_RESP = 0x80000009
+	SUBMIT_SM             = 0x00000004
+	SUBMIT_SM_RESP        = 0x80000004
+	DELIVER_SM            = 0x00000005
+	DELIVER_SM_RESP       = 0x80000005
+	UNBIND                = 0x00000006
+	UNBIND_RESP           = 0x80000006
+	QUERY_SM              = 0x00000003
+	QUERY_SM_RESP         = 0x80000003
+	REPLACE_SM            = 0x00000007
+	REPLACE_SM_RESP       = 0x80000007
+	CANCEL_SM             = 0x00000008
+	CANCEL_SM_RESP        = 0x80000008
+	SUBMIT_MULTI          = 0x00000021
+	SUBMIT_MULTI_RESP     = 0x80000021
+	DATA_SM               = 0x00000103
+	DATA_SM_RESP          = 0x80000103
+	ENQUIRE_LINK          = 0x00000015
+	ENQUIRE_LINK_RESP     = 0x80000015
 )
 
 // command status
 
 const (
-	STS_OK            = 0x00000000
-	STS_INVALID_CMD   = 0x00000003
-	STS_INV_BIND_STS  = 0x00000004
-	STS_ALREADY_BOUND = 0x00000005
-	STS_SYS_ERROR     = 0x00000008
+	STS_OK               = 0x00000000
+	STS_INV_MSG_LEN      = 0x00000001
+	STS_INV_CMD_LEN      = 0x00000002
+	STS_INVALID_CMD      = 0x00000003
+	STS_INV_BIND_STS     = 0x00000004
+	STS_ALREADY_BOUND    = 0x00000005
+	STS_INV_PRT_FLG      = 0x00000006
+	STS_INV_REG_DLV_FLG  = 0x00000007
+	STS_SYS_ERROR        = 0x00000008
+	STS_INV_SRC_ADR      = 0x0000000A
+	STS_INV_DST_ADR      = 0x0000000B
+	STS_INV_MSG_ID       = 0x0000000C
+	STS_BIND_FAILED      = 0x0000000D
+	STS_INV_PASSWD       = 0x0000000E
+	STS_INV_SYS_ID       = 0x0000000F
+	STS_CANCEL_FAIL      = 0x00000011
+	STS_REPLACE_FAIL     = 0x00000013
+	STS_INV_SERVICE_TYPE = 0x00000015
+	STS_INV_NUM_DESTS    = 0x00000033
+	STS_INV_DL_NAME      = 0x00000034
+	STS_INV_DEST_FLAG    = 0x00000040
+	STS_INV_ESM_CLASS    = 0x00000043
+	STS_SUBMIT_FAIL      = 0x00000045
+	STS_INV_SYSTEM_TYPE  = 0x00000053
+	STS_INV_REP_FLAG     = 0x00000054
+	STS_INV_SCHED        = 0x00000061
+	STS_INV_EXPIRY       = 0x00000062
+	STS_QUERY_FAIL       = 0x00000067
+	STS_INV_TLV_STREAM   = 0x000000C0 // ESME_RINVOPTPARSTREAM in SMPP 3.4
+	STS_INV_TLV_LEN      = 0x000000C2 // ESME_RINVPARLEN in SMPP 3.4
+	STS_INV_TLV_VAL      = 0x000000C4 // ESME_RINVOPTPARAMVAL in SMPP 3.4
+	STS_INV_DCS          = 0x00000104 // defined only since SMPP 5.0
 )
 
+// max length of the whole PDU accepted by smscsim. Big enough for 64K message_payload TLV
+const MAX_PDU_LEN = 0x00011000
+
 // data coding
 
 const (
-	CODING_DEFAULT = 0x00
-	CODING_UCS2    = 0x08
+	CODING_DEFAULT  = 0x00 // SMSC default alphabet (GSM7)
+	CODING_IA5      = 0x01
+	CODING_OCTET    = 0x02 // 8-bit binary
+	CODING_LATIN1   = 0x03
+	CODING_BINARY   = 0x04 // 8-bit binary
+	CODING_CYRILLIC = 0x06
+	CODING_UCS2     = 0x08
+	CODING_FLASH    = 0xF0 // default alphabet, message class 0
 )
 
 // optional parameters
 
 const (
-	TLV_RECEIPTED_MSG_ID = 0x001E
-	TLV_MESSAGE_STATE    = 0x0427
+	TLV_RECEIPTED_MSG_ID   = 0x001E
+	TLV_USER_MSG_REF       = 0x0204
+	TLV_SAR_MSG_REF_NUM    = 0x020C
+	TLV_SAR_TOTAL_SEGMENTS = 0x020E
+	TLV_SAR_SEGMENT_SEQNUM = 0x020F
+	TLV_MESSAGE_PAYLOAD    = 0x0424
+	TLV_MESSAGE_STATE      = 0x0427
 )
 
-type Session struct {
-	SystemId  string
-	Conn      net.Conn
-	ReceiveMo bool
-}
-
 type Tlv struct {
 	Tag   int
 	Len   int
@@ -68,13 +116,37 @@ type Tlv struct {
 }
 
 type Smsc struct {
-	Sessions      map[int]Session
+	Sessions      *SessionRegistry
 	FailedSubmits bool
+	Accounts      *Accounts
+	Store         *MessageStore
+	Unsuccess     []UnsuccessRule
+	Timers        SessionTimers
+	Dlr           DlrPolicy
+	Routes        Routes
+	Concat        *ConcatStore
+	Gsm7Packed    bool // GSM7 short messages are packed (8 septets in 7 octets)
+	ManualDlr     bool // messages stay ENROUTE until their receipts are triggered by hand
+	Traffic       *TrafficLog
 }
 
-func NewSmsc(failedSubmits bool) Smsc {
-	sessions := make(map[int]Session)
-	return Smsc{sessions, failedSubmits}
+func NewSmsc(failedSubmits bool) *Smsc {
+	sessions := NewSessionRegistry()
+	timers := SessionTimers{ResponseTimeout: DEFAULT_RESPONSE_TIMEOUT}
+	dlr := DefaultDlrPolicy()
+	if failedSubmits {
+		dlr = FailedDlrPolicy()
+	}
+	return &Smsc{
+		Sessions:      sessions,
+		FailedSubmits: failedSubmits,
+		Accounts:      NewAccounts(),
+		Store:         NewMessageStore(),
+		Timers:        timers,
+		Dlr:           dlr,
+		Concat:        NewConcatStore(),
+		Traffic:       NewTrafficLog(),
+	}
 }
 
 func (smsc *Smsc) Start(port int, wg *sync.WaitGroup) {
@@ -87,239 +159,593 @@ func (smsc *Smsc) Start(port int, wg *sync.WaitGroup) {
 	defer ln.Close()
 
 	log.Println("SMSC simulator listening on port", port)
+	smsc.Serve(ln)
+}
+
+// accept smpp connections until listener is closed
+func (smsc *Smsc) Serve(ln net.Listener) {
 	for {
 		conn, err := ln.Accept()
 		if err != nil {
-			log.Printf("error accepting new tcp connection %v", err)
-		} else {
-			go handleSmppConnection(smsc, conn)
+			if netErr, ok := err.(net.Error); ok && netErr.Temporary() {
+				log.Printf("error accepting new tcp connection %v", err)
+				continue
+			}
+			log.Printf("stop accepting tcp connections due %v", err)
+			return
 		}
+		go handleSmppConnection(smsc, conn)
 	}
 }
 
 func (smsc *Smsc) BoundSystemIds() []string {
 	var systemIds []string
-	for _, sess := range smsc.Sessions {
+	for _, sess := range smsc.Sessions.List() {
 		systemId := sess.SystemId
 		systemIds = append(systemIds, systemId)
 	}
 	return systemIds
 }
 
-func (smsc *Smsc) SendMoMessage(sender, recipient, message, systemId string) error {
+// options of the MO message delivery
+type MoOptions struct {
+	DataSm       bool   // deliver message with single data_sm PDU instead of deliver_sm
+	Coding       string // name of the data_coding from MO_CODINGS, empty for automatic selection
+	Segmentation string // how long message is delivered, one of SEGMENTATION_* values, empty for UDH
+	Ref16        bool   // use 16-bit concatenation reference in UDH
+	ForceRef     bool   // use Ref instead of the next concatenation reference of the session
+	Ref          uint16
+}
+
+// segmentation modes of long MO messages
+const (
+	SEGMENTATION_UDH     = "udh"     // deliver_sm parts with concatenation UDH
+	SEGMENTATION_SAR     = "sar"     // deliver_sm parts with sar_* TLVs
+	SEGMENTATION_PAYLOAD = "payload" // single deliver_sm with message_payload TLV
+)
+
+// short message and optional params of the MO message part
+type moPart struct {
+	ShortMessage []byte
+	EsmClass     byte
+	Tlvs         []Tlv
+}
+
+// MoError is returned when MO message cannot be sent, Code is one of MO_ERR_* values
+type MoError struct {
+	Code    string
+	Message string
+}
+
+func (e *MoError) Error() string {
+	return e.Message
+}
+
+const (
+	MO_ERR_NO_SESSION      = "no_session"
+	MO_ERR_NOT_RECEIVER    = "not_receiver"
+	MO_ERR_INVALID_MESSAGE = "invalid_message"
+	MO_ERR_NETWORK         = "network_error"
+)
+
+// send MO message to the session of systemId. Returns sequence numbers of the sent parts
+func (smsc *Smsc) SendMoMessage(sender, recipient, message, systemId string, opts MoOptions) ([]uint32, error) {
+	sessions := smsc.Sessions.FindBySystemId(systemId)
+	if len(sessions) == 0 {
+		log.Printf("Cannot send MO message to systemId: [%s]. No bound session found", systemId)
+		return nil, &MoError{MO_ERR_NO_SESSION, fmt.Sprintf("No session found for systemId: [%s]", systemId)}
+	}
+
 	var session *Session = nil
-	for _, sess := range smsc.Sessions {
-		if systemId == sess.SystemId {
-			session = &sess
+	for _, sess := range sessions {
+		if sess.ReceiveMo {
+			session = sess
 			break
 		}
 	}
-
 	if session == nil {
-		log.Printf("Cannot send MO message to systemId: [%s]. No bound session found", systemId)
-		return fmt.Errorf("No session found for systemId: [%s]", systemId)
-	}
-
-	if !session.ReceiveMo {
 		log.Printf("Cannot send MO message to systemId: [%s]. Only RECEIVER and TRANSCEIVER sessions could receive MO messages", systemId)
-		return fmt.Errorf("Only RECEIVER and TRANSCEIVER sessions could receive MO messages")
+		return nil, &MoError{MO_ERR_NOT_RECEIVER, "Only RECEIVER and TRANSCEIVER sessions could receive MO messages"}
 	}
 
-	udhParts := toUdhParts(toUcs2Coding(message))
-	esmClass := byte(0x00)
-	if len(udhParts) > 1 {
-		esmClass = 0x40
+	var pdus [][]byte
+	var seqNums []uint32
+	ref := opts.Ref
+	if !opts.ForceRef {
+		ref = session.concatRefs.Next()
+	} else if ref > 0xFF && !opts.Ref16 && opts.Segmentation != SEGMENTATION_SAR {
+		return nil, &MoError{MO_ERR_INVALID_MESSAGE, fmt.Sprintf("Concatenation reference [%d] does not fit 8 bits", ref)}
 	}
-	var tlvs []Tlv
-	for i := range udhParts {
-		pdu := deliverSmPDU(sender, recipient, udhParts[i], CODING_UCS2, rand.Int(), esmClass, tlvs)
-		if _, err := session.Conn.Write(pdu); err != nil {
+	parts, coding, err := smsc.moParts(message, opts, ref)
+	if err != nil {
+		log.Printf("Cannot send MO message to systemId: [%s]. %v", systemId, err)
+		return nil, &MoError{MO_ERR_INVALID_MESSAGE, err.Error()}
+	}
+	for _, part := range parts {
+		seqNum := int(rand.Int31())
+		if opts.DataSm {
+			pdus = append(pdus, dataSmPDU(sender, recipient, part.ShortMessage, coding, seqNum, part.EsmClass, part.Tlvs))
+		} else {
+			pdus = append(pdus, deliverSmPDU(sender, recipient, part.ShortMessage, coding, seqNum, part.EsmClass, part.Tlvs))
+		}
+		seqNums = append(seqNums, uint32(seqNum))
+	}
+	for i, pdu := range pdus {
+		if err := session.Write(pdu); err != nil {
 			log.Printf("Cannot send MO message to systemId: [%s]. Network error [%v]", systemId, err)
-			return fmt.Errorf("Cannot send MO message. Network error")
+			return nil, &MoError{MO_ERR_NETWORK, "Cannot send MO message. Network error"}
 		}
+		part := SubmitSm{SourceAddr: sender, DestinationAddr: recipient, EsmClass: parts[i].EsmClass,
+			DataCoding: coding, ShortMessage: parts[i].ShortMessage, Tlvs: parts[i].Tlvs}
+		smsc.Traffic.Add(TrafficEntry{Kind: TRAFFIC_MO, SystemId: systemId, SourceAddr: sender,
+			DestinationAddr: recipient, Text: smsc.smText(&part), Pdu: pdu})
 	}
 	log.Printf("MO message to systemId: [%s] was successfully sent. Sender: [%s], recipient: [%s]", systemId, sender, recipient)
-	return nil
+	return seqNums, nil
+}
+
+// encode MO message with the coding selected by name (see MO_CODINGS). Empty coding name
+// selects GSM7 default alphabet if the message fits and UCS2 otherwise
+func encodeMoText(message, codingName string) ([]byte, byte, error) {
+	if codingName == "" {
+		if septets, ok := encodeGsm7(message); ok {
+			return septets, CODING_DEFAULT, nil
+		}
+		return toUcs2Coding(message), CODING_UCS2, nil
+	}
+	coding, ok := MO_CODINGS[codingName]
+	if !ok {
+		return nil, 0, fmt.Errorf("Unknown coding [%s]", codingName)
+	}
+	data, err := encodeText(message, coding)
+	return data, coding, err
+}
+
+// encode MO message and split it into parts according to the segmentation mode. Message
+// sent with data_sm is never split, its short message is sent in message_payload TLV
+func (smsc *Smsc) moParts(message string, opts MoOptions, ref uint16) ([]moPart, byte, error) {
+	data, coding, err := encodeMoText(message, opts.Coding)
+	if err != nil {
+		return nil, 0, err
+	}
+	gsm7 := isGsm7Coding(coding)
+	pack := func(septets []byte, fillBits int) []byte {
+		if gsm7 && smsc.Gsm7Packed {
+			return packGsm7(septets, fillBits)
+		}
+		return septets
+	}
+
+	switch opts.Segmentation {
+	case "", SEGMENTATION_UDH, SEGMENTATION_SAR:
+	case SEGMENTATION_PAYLOAD:
+		if !opts.DataSm {
+			data = pack(data, 0)
+			return []moPart{{Tlvs: []Tlv{{TLV_MESSAGE_PAYLOAD, len(data), data}}}}, coding, nil
+		}
+	default:
+		return nil, 0, fmt.Errorf("Unknown segmentation [%s]", opts.Segmentation)
+	}
+	if opts.DataSm {
+		return []moPart{{ShortMessage: pack(data, 0)}}, coding, nil
+	}
+
+	udhLen := 0 // parts with SAR TLVs have no UDH
+	if opts.Segmentation != SEGMENTATION_SAR {
+		udhLen = len(concatUdh(ref, 1, 1, opts.Ref16))
+	}
+	var chunks [][]byte
+	if gsm7 {
+		chunks = splitGsm7(data, udhLen)
+	} else {
+		chunks = splitOctets(data, udhLen, isUcs2Coding(coding))
+	}
+	if len(chunks) == 1 {
+		return []moPart{{ShortMessage: pack(data, 0)}}, coding, nil
+	}
+	parts := make([]moPart, len(chunks))
+	for i, chunk := range chunks {
+		if opts.Segmentation == SEGMENTATION_SAR {
+			parts[i] = moPart{pack(chunk, 0), 0x00, sarTlvs(ref, len(chunks), i+1)}
+		} else {
+			udh := concatUdh(ref, len(chunks), i+1, opts.Ref16)
+			parts[i] = moPart{append(udh, pack(chunk, gsm7FillBits(udhLen))...), 0x40, nil}
+		}
+	}
+	return parts, coding, nil
 }
 
 // how to convert ints to and from bytes https://golang.org/pkg/encoding/binary/
 
 func handleSmppConnection(smsc *Smsc, conn net.Conn) {
-	sessionId := rand.Int()
+	stats := &SessionStats{}
+	conn = &smppConn{Conn: conn, stats: stats}
+	sessionId := smsc.Sessions.nextId()
 	systemId := "anonymous"
 	bound := false
 	receiver := false
 
-	defer delete(smsc.Sessions, sessionId)
+	openedAt := time.Now()
+	var lastRequestAt time.Time // last PDU originated by the ESME
+	var unbindSentAt time.Time  // set when smscsim waits for unbind_resp
+	var unbindRequested int32   // set by UnbindSession, which sends unbind on behalf of the handler
+	enquireLinkResps := make(chan uint32, 1)
+	var stopEnquireLink chan struct{} // closed to stop enquire_link loop of the bound session
+	done := make(chan struct{})
+
+	defer smsc.Sessions.Remove(sessionId)
 	defer conn.Close()
+	defer close(done)
+	defer func() {
+		if stopEnquireLink != nil {
+			close(stopEnquireLink)
+		}
+	}()
 
 	for {
+		conn.SetReadDeadline(smsc.Timers.readDeadline(bound, openedAt, lastRequestAt, unbindSentAt))
+
 		// read PDU header
 		pduHeadBuf := make([]byte, 16)
-		if _, err := io.ReadFull(conn, pduHeadBuf); err != nil {
-			log.Printf("closing connection for system_id[%s] due %v\n", systemId, err)
-			return
+		if n, err := io.ReadFull(conn, pduHeadBuf); err != nil {
+			if n > 0 || !isTimeout(err) {
+				log.Printf("closing connection for system_id[%s] due %v\n", systemId, err)
+				return
+			}
+			if !bound {
+				log.Printf("session was not bound in %v. closing connection", smsc.Timers.SessionInitTimeout)
+				return
+			}
+			if !unbindSentAt.IsZero() {
+				log.Printf("no unbind_resp from system_id[%s] in %v. closing connection", systemId, smsc.Timers.ResponseTimeout)
+				return
+			}
+			log.Printf("no requests from system_id[%s] in %v. sending unbind", systemId, smsc.Timers.InactivityTimeout)
+			if _, err := conn.Write(headerPDU(UNBIND, STS_OK, uint32(rand.Int31()))); err != nil {
+				log.Printf("error sending unbind to system_id[%s] due %v. closing connection", systemId, err)
+				return
+			}
+			unbindSentAt = time.Now()
+			continue
 		}
 		cmdLen := binary.BigEndian.Uint32(pduHeadBuf[0:])
 		cmdId := binary.BigEndian.Uint32(pduHeadBuf[4:])
 		// cmdSts := binary.BigEndian.Uint32(pduHeadBuf[8:])
 		seqNum := binary.BigEndian.Uint32(pduHeadBuf[12:])
 
+		if cmdLen < 16 || cmdLen > MAX_PDU_LEN {
+			// we cannot find the start of the next PDU in the stream, so connection must be closed
+			log.Printf("invalid command_length %d from system_id[%s]. closing connection", cmdLen, systemId)
+			conn.Write(headerPDU(GENERIC_NACK, STS_INV_CMD_LEN, seqNum))
+			return
+		}
+
+		// read PDU body
+		var pduBody []byte
+		if cmdLen > 16 {
+			pduBody = make([]byte, cmdLen-16)
+			if _, err := io.ReadFull(conn, pduBody); err != nil {
+				log.Printf("error reading pdu body for system_id[%s] due %v. closing connection", systemId, err)
+				return
+			}
+		}
+
+		stats.countIn(cmdId)
+		if cmdId&0x80000000 == 0 { // responses have the high bit of command_id set
+			lastRequestAt = time.Now()
+		}
+		pdu := append(pduHeadBuf, pduBody...)
 		var respBytes []byte
 
 		switch cmdId {
 		case BIND_RECEIVER, BIND_TRANSMITTER, BIND_TRANSCEIVER: // bind requests
 			{
-				pduBody := make([]byte, cmdLen-16)
-				if _, err := io.ReadFull(conn, pduBody); err != nil {
-					log.Printf("closing connection due %v\n", err)
-					return
-				}
+				respCmdId := 2147483648 + cmdId // hack to calc resp cmd id
 
-				// find first null terminator
-				idx := bytes.IndexByte(pduBody, byte(0))
-				if idx == -1 {
-					log.Printf("invalid pdu_body. cannot find system_id. closing connection")
-					return
+				bind, err := parseBind(pduBody)
+				if err != nil {
+					log.Printf("invalid bind request: %v", err)
+					respBytes = headerPDU(respCmdId, pduErrorStatus(err), seqNum)
+					break
 				}
-				systemId = string(pduBody[:idx])
-				log.Printf("bind request from system_id[%s]\n", systemId)
-
-				respCmdId := 2147483648 + cmdId // hack to calc resp cmd id
+				bindSystemId := bind.SystemId
+				log.Printf("bind request from system_id[%s]\n", bindSystemId)
 
 				if bound {
 					respBytes = headerPDU(respCmdId, STS_ALREADY_BOUND, seqNum)
 					log.Printf("[%s] already has bound session", systemId)
+				} else if err := smsc.Accounts.Authenticate(bind); err != nil {
+					respBytes = headerPDU(respCmdId, pduErrorStatus(err), seqNum)
+					log.Printf("bind request from system_id[%s] rejected: %v", bindSystemId, err)
 				} else {
+					systemId = bindSystemId
 					receiveMo := cmdId == BIND_RECEIVER || cmdId == BIND_TRANSCEIVER
-					smsc.Sessions[sessionId] = Session{systemId, conn, receiveMo}
+					smsc.Sessions.Add(&Session{
+						Id:         sessionId,
+						SystemId:   systemId,
+						ReceiveMo:  receiveMo,
+						BindType:   bindTypeName(cmdId),
+						RemoteAddr: conn.RemoteAddr().String(),
+						BoundSince: time.Now(),
+						Stats:      stats,
+						conn:       conn,
+						closed:     done,
+						unbindSent: &unbindRequested,
+						concatRefs: newConcatRefs(),
+					})
 					respBytes = stringBodyPDU(respCmdId, STS_OK, seqNum, "smscsim")
 					bound = true
 					receiver = cmdId == BIND_RECEIVER
+					if smsc.Timers.EnquireLinkInterval > 0 {
+						stopEnquireLink = make(chan struct{})
+						go smsc.enquireLinkLoop(conn, systemId, enquireLinkResps, stopEnquireLink)
+					}
 				}
 			}
 		case UNBIND: // unbind request
 			{
 				log.Printf("unbind request from system_id[%s]\n", systemId)
 				respBytes = headerPDU(UNBIND_RESP, STS_OK, seqNum)
+				smsc.Sessions.Remove(sessionId)
 				bound = false
 				systemId = "anonymous"
+				openedAt = time.Now() // restart session init timer
+				unbindSentAt = time.Time{}
+				atomic.StoreInt32(&unbindRequested, 0)
+				if stopEnquireLink != nil {
+					close(stopEnquireLink)
+					stopEnquireLink = nil
+				}
+			}
+		case UNBIND_RESP: // unbind_resp for unbind sent by smscsim
+			{
+				if unbindSentAt.IsZero() && atomic.LoadInt32(&unbindRequested) == 0 {
+					log.Printf("unexpected unbind_resp from system_id[%s] is ignored\n", systemId)
+					break
+				}
+				log.Printf("unbind_resp from system_id[%s]. closing connection\n", systemId)
+				return
+			}
+		case ENQUIRE_LINK_RESP: // enquire_link_resp for enquire_link sent by smscsim
+			{
+				log.Printf("enquire_link_resp from system_id[%s]\n", systemId)
+				select {
+				case enquireLinkResps <- seqNum:
+				default:
+					// unexpected enquire_link_resp, nobody waits for it
+				}
 			}
 		case ENQUIRE_LINK: // enquire_link
 			{
 				log.Printf("enquire_link from system_id[%s]\n", systemId)
+				if len(pduBody) > 0 {
+					respBytes = headerPDU(ENQUIRE_LINK_RESP, STS_INV_CMD_LEN, seqNum)
+					break
+				}
 				respBytes = headerPDU(ENQUIRE_LINK_RESP, STS_OK, seqNum)
 			}
 		case SUBMIT_SM: // submit_sm
 			{
-				pduBody := make([]byte, cmdLen-16)
-				if _, err := io.ReadFull(conn, pduBody); err != nil {
-					log.Printf("error reading submit_sm body for %s due %v. closing connection", systemId, err)
-					return
-				}
 				log.Printf("submit_sm from system_id[%s]\n", systemId)
 
+				if !bound {
+					respBytes = headerPDU(SUBMIT_SM_RESP, STS_INV_BIND_STS, seqNum)
+					log.Printf("error handling submit_sm. session is not bound")
+					break
+				}
+
 				if receiver {
 					respBytes = headerPDU(SUBMIT_SM_RESP, STS_INV_BIND_STS, seqNum)
 					log.Printf("error handling submit_sm from system_id[%s]. session with bind type RECEIVER cannot send requests", systemId)
 					break
 				}
 
-				idxCounter := 0
-				nullTerm := byte(0)
+				sm, err := parseSubmitSm(pduBody)
+				if err == nil {
+					err = validateSubmitSm(sm)
+				}
+				if err != nil {
+					log.Printf("invalid submit_sm from system_id[%s]: %v", systemId, err)
+					respBytes = headerPDU(SUBMIT_SM_RESP, pduErrorStatus(err), seqNum)
+					break
+				}
+				// prepare submit_sm_resp
+				msgId := strconv.Itoa(rand.Int())
 
-				srvTypeEndIdx := bytes.IndexByte(pduBody, nullTerm)
-				if srvTypeEndIdx == -1 {
-					respBytes = headerPDU(GENERIC_NACK, STS_INVALID_CMD, seqNum)
+				if smsc.FailedSubmits && seqNum%2 == 0 {
+					// return error response
+					respBytes = headerPDU(SUBMIT_SM_RESP, STS_SYS_ERROR, seqNum)
 					break
 				}
-				idxCounter = idxCounter + srvTypeEndIdx
-				idxCounter = idxCounter + 3 // skip src ton and npi
 
-				srcAddrEndIdx := bytes.IndexByte(pduBody[idxCounter:], nullTerm)
-				if srcAddrEndIdx == -1 {
-					respBytes = headerPDU(GENERIC_NACK, STS_INVALID_CMD, seqNum)
+				route := smsc.Routes.Find(systemId, sm)
+				if route != nil && route.Status != STS_OK {
+					log.Printf("submit_sm from system_id[%s] to [%s] rejected by route with status 0x%08X", systemId, sm.DestinationAddr, route.Status)
+					respBytes = headerPDU(SUBMIT_SM_RESP, route.Status, seqNum)
 					break
 				}
-				srcAddr := string(pduBody[idxCounter : idxCounter+srcAddrEndIdx])
-				idxCounter = idxCounter + srcAddrEndIdx
-				idxCounter = idxCounter + 3 // skip dest ton and npi
 
-				destAddrEndIdx := bytes.IndexByte(pduBody[idxCounter:], nullTerm)
-				if destAddrEndIdx == -1 {
-					respBytes = headerPDU(GENERIC_NACK, STS_INVALID_CMD, seqNum)
+				replacedId := ""
+				if sm.ReplaceIfPresent == 1 {
+					replacedId = smsc.replaceIfPresent(systemId, sm)
+				}
+				if replacedId != "" {
+					log.Printf("submit_sm from system_id[%s] replaced pending message [%s]", systemId, replacedId)
+					respBytes = stringBodyPDU(SUBMIT_SM_RESP, STS_OK, seqNum, replacedId)
+					smsc.logSubmit(systemId, replacedId, sm, nil, pdu)
+				} else {
+					respBytes = stringBodyPDU(SUBMIT_SM_RESP, STS_OK, seqNum, msgId)
+					key := smsc.Store.Add(Message{Id: msgId, CmdId: SUBMIT_SM, SystemId: systemId, Sm: sm, State: STATE_ENROUTE, SubmitDate: time.Now()})
+					smsc.logSubmit(systemId, msgId, sm, nil, pdu)
+					smsc.addSegment(systemId, msgId, sm)
+					smsc.scheduleDelivery(key, smsc.dlrPolicy(route).Delay.Sample(), conn)
+				}
+			}
+		case SUBMIT_MULTI: // submit_multi
+			{
+				log.Printf("submit_multi from system_id[%s]\n", systemId)
+
+				if !bound || receiver {
+					respBytes = headerPDU(SUBMIT_MULTI_RESP, STS_INV_BIND_STS, seqNum)
+					log.Printf("error handling submit_multi from system_id[%s]. session is not bound as TRANSMITTER or TRANSCEIVER", systemId)
 					break
 				}
-				destAddr := string(pduBody[idxCounter : idxCounter+destAddrEndIdx])
-				idxCounter = idxCounter + destAddrEndIdx
-				idxCounter = idxCounter + 4 // skip esm_class, protocol_id, priority_flag
 
-				schedEndIdx := bytes.IndexByte(pduBody[idxCounter:], nullTerm)
-				if schedEndIdx == -1 {
-					respBytes = headerPDU(GENERIC_NACK, STS_INVALID_CMD, seqNum)
+				sm, err := parseSubmitMulti(pduBody)
+				if err == nil {
+					err = validateSmFields(&sm.SubmitSm)
+				}
+				if err != nil {
+					log.Printf("invalid submit_multi from system_id[%s]: %v", systemId, err)
+					respBytes = headerPDU(SUBMIT_MULTI_RESP, pduErrorStatus(err), seqNum)
 					break
 				}
-				idxCounter = idxCounter + schedEndIdx
-				idxCounter = idxCounter + 1 // next is validity period
 
-				validityEndIdx := bytes.IndexByte(pduBody[idxCounter:], nullTerm)
-				if validityEndIdx == -1 {
-					respBytes = headerPDU(GENERIC_NACK, STS_INVALID_CMD, seqNum)
+				if smsc.FailedSubmits && seqNum%2 == 0 {
+					// return error response
+					respBytes = headerPDU(SUBMIT_MULTI_RESP, STS_SYS_ERROR, seqNum)
 					break
 				}
-				idxCounter = idxCounter + validityEndIdx
-				registeredDlr := pduBody[idxCounter+1] // registered_delivery is next field after the validity_period
 
-				// prepare submit_sm_resp
-				msgId := strconv.Itoa(rand.Int())
+				msgId, unsuccess := smsc.submitMulti(systemId, sm, conn)
+				respBytes = submitMultiRespPDU(seqNum, msgId, unsuccess)
+				dests := make([]string, len(sm.Dests))
+				for i, dest := range sm.Dests {
+					dests[i] = dest.Addr
+				}
+				smsc.logSubmit(systemId, msgId, &sm.SubmitSm, dests, pdu)
+			}
+		case CANCEL_SM: // cancel_sm
+			{
+				log.Printf("cancel_sm from system_id[%s]\n", systemId)
+
+				if !bound || receiver {
+					respBytes = headerPDU(CANCEL_SM_RESP, STS_INV_BIND_STS, seqNum)
+					log.Printf("error handling cancel_sm from system_id[%s]. session is not bound as TRANSMITTER or TRANSCEIVER", systemId)
+					break
+				}
+
+				c, err := parseCancelSm(pduBody)
+				if err == nil {
+					err = smsc.cancelMessages(systemId, c)
+				}
+				if err != nil {
+					log.Printf("cannot cancel message for system_id[%s]: %v", systemId, err)
+					respBytes = headerPDU(CANCEL_SM_RESP, pduErrorStatus(err), seqNum)
+					break
+				}
+				respBytes = headerPDU(CANCEL_SM_RESP, STS_OK, seqNum)
+			}
+		case REPLACE_SM: // replace_sm
+			{
+				log.Printf("replace_sm from system_id[%s]\n", systemId)
+
+				if !bound || receiver {
+					respBytes = headerPDU(REPLACE_SM_RESP, STS_INV_BIND_STS, seqNum)
+					log.Printf("error handling replace_sm from system_id[%s]. session is not bound as TRANSMITTER or TRANSCEIVER", systemId)
+					break
+				}
+
+				rs, err := parseReplaceSm(pduBody)
+				if err == nil {
+					err = smsc.replaceMessage(systemId, rs)
+				}
+				if err != nil {
+					log.Printf("cannot replace message for system_id[%s]: %v", systemId, err)
+					respBytes = headerPDU(REPLACE_SM_RESP, pduErrorStatus(err), seqNum)
+					break
+				}
+				respBytes = headerPDU(REPLACE_SM_RESP, STS_OK, seqNum)
+			}
+		case QUERY_SM: // query_sm
+			{
+				log.Printf("query_sm from system_id[%s]\n", systemId)
+
+				if !bound || receiver {
+					respBytes = headerPDU(QUERY_SM_RESP, STS_INV_BIND_STS, seqNum)
+					log.Printf("error handling query_sm from system_id[%s]. session is not bound as TRANSMITTER or TRANSCEIVER", systemId)
+					break
+				}
+
+				q, err := parseQuerySm(pduBody)
+				if err != nil {
+					log.Printf("invalid query_sm from system_id[%s]: %v", systemId, err)
+					respBytes = headerPDU(QUERY_SM_RESP, pduErrorStatus(err), seqNum)
+					break
+				}
+
+				msg, found := smsc.queryMessage(q.MessageId)
+				if !found || msg.SystemId != systemId {
+					log.Printf("query_sm from system_id[%s] for unknown message [%s]", systemId, q.MessageId)
+					respBytes = headerPDU(QUERY_SM_RESP, STS_INV_MSG_ID, seqNum)
+					break
+				}
+				if msg.Sm.SourceAddr != q.SourceAddr {
+					log.Printf("query_sm from system_id[%s] for message [%s] with wrong source_addr [%s]", systemId, q.MessageId, q.SourceAddr)
+					respBytes = headerPDU(QUERY_SM_RESP, STS_QUERY_FAIL, seqNum)
+					break
+				}
+				respBytes = querySmRespPDU(seqNum, msg)
+			}
+		case DATA_SM: // data_sm
+			{
+				log.Printf("data_sm from system_id[%s]\n", systemId)
+
+				if !bound || receiver {
+					respBytes = headerPDU(DATA_SM_RESP, STS_INV_BIND_STS, seqNum)
+					log.Printf("error handling data_sm from system_id[%s]. session is not bound as TRANSMITTER or TRANSCEIVER", systemId)
+					break
+				}
+
+				sm, err := parseDataSm(pduBody)
+				if err == nil {
+					err = validateSubmitSm(sm)
+				}
+				if err != nil {
+					log.Printf("invalid data_sm from system_id[%s]: %v", systemId, err)
+					respBytes = headerPDU(DATA_SM_RESP, pduErrorStatus(err), seqNum)
+					break
+				}
 
 				if smsc.FailedSubmits && seqNum%2 == 0 {
 					// return error response
-					respBytes = headerPDU(SUBMIT_SM_RESP, STS_SYS_ERROR, seqNum)
-				} else {
-					respBytes = stringBodyPDU(SUBMIT_SM_RESP, STS_OK, seqNum, msgId)
-					// send DLR if necessary
-					if registeredDlr != 0 {
-						go func() {
-							time.Sleep(2000 * time.Millisecond)
-							now := time.Now()
-							dlr := deliveryReceiptPDU(destAddr, srcAddr, msgId, now, now, smsc.FailedSubmits)
-							if _, err := conn.Write(dlr); err != nil {
-								log.Printf("error sending delivery receipt to system_id[%s] due %v.", systemId, err)
-								return
-							} else {
-								log.Printf("delivery receipt for message [%s] was send to system_id[%s]", msgId, systemId)
-							}
-						}()
-					}
+					respBytes = headerPDU(DATA_SM_RESP, STS_SYS_ERROR, seqNum)
+					break
+				}
+
+				route := smsc.Routes.Find(systemId, sm)
+				if route != nil && route.Status != STS_OK {
+					log.Printf("data_sm from system_id[%s] to [%s] rejected by route with status 0x%08X", systemId, sm.DestinationAddr, route.Status)
+					respBytes = headerPDU(DATA_SM_RESP, route.Status, seqNum)
+					break
 				}
+
+				msgId := strconv.Itoa(rand.Int())
+				respBytes = stringBodyPDU(DATA_SM_RESP, STS_OK, seqNum, msgId)
+				key := smsc.Store.Add(Message{Id: msgId, CmdId: DATA_SM, SystemId: systemId, Sm: sm, State: STATE_ENROUTE, SubmitDate: time.Now()})
+				smsc.logSubmit(systemId, msgId, sm, nil, pdu)
+				smsc.addSegment(systemId, msgId, sm)
+				smsc.scheduleDelivery(key, smsc.dlrPolicy(route).Delay.Sample(), conn)
 			}
 		case DELIVER_SM_RESP: // deliver_sm_resp
 			{
-				if cmdLen > 16 {
-					buf := make([]byte, cmdLen-16)
-					if _, err := io.ReadFull(conn, buf); err != nil {
-						log.Printf("error reading deliver_sm_resp for %s due %v. closing connection", systemId, err)
-						return
-					}
-				}
 				log.Println("deliver_sm_resp from", systemId)
 			}
+		case DATA_SM_RESP: // data_sm_resp
+			{
+				log.Println("data_sm_resp from", systemId)
+			}
 		default:
 			{
-				if cmdLen > 16 {
-					buf := make([]byte, cmdLen-16)
-					if _, err := io.ReadFull(conn, buf); err != nil {
-						log.Printf("error reading pdu for %s due %v. closing connection", systemId, err)
-						return
-					}
-				}
 				log.Printf("unsupported pdu cmd_id(%d) from %s", cmdId, systemId)
 				// generic nack packet with status "Invalid Command ID"
 				respBytes = headerPDU(GENERIC_NACK, STS_INVALID_CMD, seqNum)
 			}
 		}
 
+		if len(respBytes) == 0 {
+			continue // nothing to respond to *_resp PDUs
+		}
 		if _, err := conn.Write(respBytes); err != nil {
 			log.Printf("error sending response to system_id[%s] due %v. closing connection", systemId, err)
 			return
@@ -348,19 +774,84 @@ func stringBodyPDU(cmdId, cmdSts, seqNum uint32, body string) []byte {
 	return buf
 }
 
-const DLR_RECEIPT_FORMAT = "id:%s sub:001 dlvrd:001 submit date:%s done date:%s stat:DELIVRD err:000 Text:..."
-const DLR_RECEIPT_FORMAT_FAILED = "id:%s sub:001 dlvrd:000 submit date:%s done date:%s stat:UNDELIV err:069 Text:..."
+func querySmRespPDU(seqNum uint32, msg Message) []byte {
+	finalDate := ""
+	if msg.IsFinal() {
+		finalDate = formatSmppTime(msg.FinalDate)
+	}
+
+	var body bytes.Buffer
+	body.WriteString(msg.Id)
+	body.WriteByte(0) // null term
+	body.WriteString(finalDate)
+	body.WriteByte(0) // null term
+	body.WriteByte(msg.State)
+	body.WriteByte(byte(msg.ErrorCode))
+
+	cmdLen := 16 + body.Len()
+	buf := make([]byte, 16)
+	binary.BigEndian.PutUint32(buf[0:], uint32(cmdLen))
+	binary.BigEndian.PutUint32(buf[4:], QUERY_SM_RESP)
+	binary.BigEndian.PutUint32(buf[8:], STS_OK)
+	binary.BigEndian.PutUint32(buf[12:], seqNum)
+	return append(buf, body.Bytes()...)
+}
+
+func submitMultiRespPDU(seqNum uint32, msgId string, unsuccess []UnsuccessSme) []byte {
+	var body bytes.Buffer
+	body.WriteString(msgId)
+	body.WriteByte(0) // null term
+	body.WriteByte(byte(len(unsuccess)))
+	for _, u := range unsuccess {
+		body.WriteByte(u.Ton)
+		body.WriteByte(u.Npi)
+		body.WriteString(u.Addr)
+		body.WriteByte(0) // null term
+		sts := make([]byte, 4)
+		binary.BigEndian.PutUint32(sts, u.Status)
+		body.Write(sts)
+	}
+
+	cmdLen := 16 + body.Len()
+	buf := make([]byte, 16)
+	binary.BigEndian.PutUint32(buf[0:], uint32(cmdLen))
+	binary.BigEndian.PutUint32(buf[4:], SUBMIT_MULTI_RESP)
+	binary.BigEndian.PutUint32(buf[8:], STS_OK)
+	binary.BigEndian.PutUint32(buf[12:], seqNum)
+	return append(buf, body.Bytes()...)
+}
 
-func deliveryReceiptPDU(src, dst, msgId string, submitDate, doneDate time.Time, failedDeliv bool) []byte {
+const DLR_RECEIPT_FORMAT = "id:%s sub:001 dlvrd:%03d submit date:%s done date:%s stat:%s err:%03d Text:..."
+
+// delivery receipt (esm_class 0x04) or intermediate notification (esm_class 0x20)
+func deliveryReceiptPDU(src, dst, msgId string, submitDate, doneDate time.Time, state byte, errCode int, esmClass byte) []byte {
+	deliveryReceipt, tlvs := deliveryReceiptContent(msgId, submitDate, doneDate, state, errCode)
+	return deliverSmPDU(src, dst, deliveryReceipt, CODING_DEFAULT, rand.Int(), esmClass, tlvs)
+}
+
+// delivery receipt for messages submitted with data_sm. Receipt text is sent in message_payload TLV
+func dataSmReceiptPDU(src, dst, msgId string, submitDate, doneDate time.Time, state byte, errCode int, esmClass byte) []byte {
+	deliveryReceipt, tlvs := deliveryReceiptContent(msgId, submitDate, doneDate, state, errCode)
+	return dataSmPDU(src, dst, deliveryReceipt, CODING_DEFAULT, rand.Int(), esmClass, tlvs)
+}
+
+// SME delivery (esm_class 0x08) or manual/user (esm_class 0x10) acknowledgement sent on behalf of
+// the recipient. Acknowledgement has empty short message, user_message_reference of the original message is echoed back
+func smeAckPDU(src, dst, msgId string, userMsgRef []Tlv, esmClass byte) []byte {
+	tlvs := []Tlv{{TLV_RECEIPTED_MSG_ID, len(msgId) + 1, append([]byte(msgId), 0)}}
+	tlvs = append(tlvs, userMsgRef...)
+	return deliverSmPDU(src, dst, nil, CODING_DEFAULT, rand.Int(), esmClass, tlvs)
+}
+
+func deliveryReceiptContent(msgId string, submitDate, doneDate time.Time, state byte, errCode int) ([]byte, []Tlv) {
 	sbtDateFrmt := submitDate.Format("0601021504")
 	doneDateFrmt := doneDate.Format("0601021504")
-	dlrFmt := DLR_RECEIPT_FORMAT
-	msgState := []byte{2}
-	if failedDeliv {
-		dlrFmt = DLR_RECEIPT_FORMAT_FAILED
-		msgState = []byte{5}
+	dlvrd := 0
+	if state == STATE_DELIVERED {
+		dlvrd = 1
 	}
-	deliveryReceipt := fmt.Sprintf(dlrFmt, msgId, sbtDateFrmt, doneDateFrmt)
+	deliveryReceipt := fmt.Sprintf(DLR_RECEIPT_FORMAT, msgId, dlvrd, sbtDateFrmt, doneDateFrmt, receiptStat(state), errCode)
+	msgState := []byte{state}
 	var tlvs []Tlv
 
 	// receipted_msg_id TLV
@@ -374,7 +865,7 @@ func deliveryReceiptPDU(src, dst, msgId string, submitDate, doneDate time.Time,
 	msgStateTlv := Tlv{TLV_MESSAGE_STATE, 1, msgState}
 	tlvs = append(tlvs, msgStateTlv)
 
-	return deliverSmPDU(src, dst, []byte(deliveryReceipt), CODING_DEFAULT, rand.Int(), 0x04, tlvs)
+	return []byte(deliveryReceipt), tlvs
 }
 
 func deliverSmPDU(sender, recipient string, shortMessage []byte, coding byte, seqNum int, esmClass byte, tlvs []Tlv) []byte {
@@ -443,46 +934,123 @@ func deliverSmPDU(sender, recipient string, shortMessage []byte, coding byte, se
 	return deliverSm.Bytes()
 }
 
+func dataSmPDU(sender, recipient string, payload []byte, coding byte, seqNum int, esmClass byte, tlvs []Tlv) []byte {
+	// header without cmd_len
+	header := make([]byte, 12)
+	binary.BigEndian.PutUint32(header[0:], uint32(DATA_SM))
+	binary.BigEndian.PutUint32(header[4:], uint32(0))
+	binary.BigEndian.PutUint32(header[8:], uint32(seqNum)) // rand seq num
+
+	// pdu body buffer
+	var buf bytes.Buffer
+	buf.Write(header)
+
+	buf.WriteString("smscsim")
+	buf.WriteByte(0) // null term
+
+	buf.WriteByte(0) // src ton
+	buf.WriteByte(0) // src npi
+	buf.WriteString(sender)
+	buf.WriteByte(0) // null term
+
+	buf.WriteByte(0) // dest ton
+	buf.WriteByte(0) // dest npi
+	buf.WriteString(recipient)
+	buf.WriteByte(0) // null term
+
+	buf.WriteByte(esmClass) // esm class
+	buf.WriteByte(0)        // registered delivery
+	buf.WriteByte(coding)   // data coding
+
+	tlvs = append(tlvs, Tlv{TLV_MESSAGE_PAYLOAD, len(payload), payload})
+	for _, t := range tlvs {
+		tlvBytes := make([]byte, 4)
+		binary.BigEndian.PutUint16(tlvBytes[0:], uint16(t.Tag))
+		binary.BigEndian.PutUint16(tlvBytes[2:], uint16(t.Len))
+		buf.Write(tlvBytes)
+		buf.Write(t.Value)
+	}
+
+	// calc cmd lenth and append to the begining
+	cmdLen := buf.Len() + 4 // +4 for cmdLen field itself
+	cmdLenBytes := make([]byte, 4)
+	binary.BigEndian.PutUint32(cmdLenBytes[0:], uint32(cmdLen))
+
+	var dataSm bytes.Buffer
+	dataSm.Write(cmdLenBytes)
+	dataSm.Write(buf.Bytes())
+
+	return dataSm.Bytes()
+}
+
+// encode text with UTF-16BE. Characters outside of the basic multilingual plane are encoded with surrogate pairs
 func toUcs2Coding(input string) []byte {
-	// not most elegant implementation, but ok for testing purposes
-	l := utf8.RuneCountInString(input)
-	buf := make([]byte, l*2) // two bytes per character
-	idx := 0
-	for len(input) > 0 {
-		r, s := utf8.DecodeRuneInString(input)
-		if r <= 65536 {
-			binary.BigEndian.PutUint16(buf[idx:], uint16(r))
-		} else {
-			binary.BigEndian.PutUint16(buf[idx:], uint16(63)) // question mark
-		}
-		input = input[s:]
-		idx += 2
+	units := utf16.Encode([]rune(input))
+	buf := make([]byte, len(units)*2) // two bytes per code unit
+	for i, u := range units {
+		binary.BigEndian.PutUint16(buf[i*2:], u)
 	}
 	return buf
 }
 
-func toUdhParts(longMsg []byte) [][]byte {
+// split long message into chunks which fit into parts with UDH of udhLen octets (0 for SAR segmentation).
+// If ucs2 is true, chunks are never ended with high surrogate, so surrogate pairs are not split
+func splitOctets(longMsg []byte, udhLen int, ucs2 bool) [][]byte {
 	msgLen := len(longMsg)
 	if msgLen <= 140 { // max len for message field in pdu
 		// one part is enough
 		return [][]byte{longMsg}
 	}
-	maxUdhContentLen := 134
-	c := int(math.Ceil(float64(msgLen) / float64(maxUdhContentLen)))
-	parts := make([][]byte, c)
-	for i := 0; i < c; i++ {
-		si := i * maxUdhContentLen
-		ei := int(math.Min(float64(si+maxUdhContentLen), float64(msgLen)))
-		partLen := ei - si
-		part := make([]byte, partLen+6) // plus 6 for udh headers
-		part[0] = 0x05
-		part[1] = 0x00
-		part[2] = 0x03
-		part[3] = 0x01 // maybe accept id as method argument?
-		part[4] = byte(c)
-		part[5] = byte(i + 1)
-		copy(part[6:], longMsg[si:ei])
-		parts[i] = part
-	}
-	return parts
+	maxUdhContentLen := 140 - udhLen
+	if ucs2 {
+		maxUdhContentLen -= maxUdhContentLen % 2
+	}
+	var chunks [][]byte
+	for si := 0; si < msgLen; {
+		ei := si + maxUdhContentLen
+		if ei >= msgLen {
+			ei = msgLen
+		} else if ucs2 && isHighSurrogate(binary.BigEndian.Uint16(longMsg[ei-2:])) {
+			ei -= 2
+		}
+		chunks = append(chunks, longMsg[si:ei])
+		si = ei
+	}
+	return chunks
+}
+
+func isHighSurrogate(u uint16) bool {
+	return u >= 0xD800 && u <= 0xDBFF
+}
+
+// UDH with concatenated message information element with 8-bit or 16-bit reference
+func concatUdh(ref uint16, total, seq int, ref16 bool) []byte {
+	if ref16 {
+		return []byte{
+			0x06, // UDH length
+			IE_CONCAT_16BIT,
+			0x04, // IE length
+			byte(ref >> 8),
+			byte(ref),
+			byte(total),
+			byte(seq),
+		}
+	}
+	return []byte{
+		0x05, // UDH length
+		IE_CONCAT_8BIT,
+		0x03, // IE length
+		byte(ref),
+		byte(total),
+		byte(seq),
+	}
+}
+
+// sar_msg_ref_num, sar_total_segments and sar_segment_seqnum TLVs
+func sarTlvs(ref uint16, total, seq int) []Tlv {
+	return []Tlv{
+		{TLV_SAR_MSG_REF_NUM, 2, []byte{byte(ref >> 8), byte(ref)}},
+		{TLV_SAR_TOTAL_SEGMENTS, 1, []byte{byte(total)}},
+		{TLV_SAR_SEGMENT_SEQNUM, 1, []byte{byte(seq)}},
+	}
 }
@@ -1,12 +1,49 @@
 package main
 
 import (
+	"encoding/binary"
 	"encoding/hex"
 	"fmt"
+	"io"
+	"net"
 	"reflect"
+	"sync"
 	"testing"
+	"time"
 )
 
+func bindTransceiverPDU(systemId string) []byte {
+	body := append([]byte(systemId), 0)           // system_id
+	body = append(body, 0, 0, 0x34, 0, 0, 0)      // password, system_type, interface_version, addr_ton, addr_npi, address_range
+	pdu := headerPDU(BIND_TRANSCEIVER, STS_OK, 1) // command_length will be fixed below
+	binary.BigEndian.PutUint32(pdu[0:], uint32(16+len(body)))
+	return append(pdu, body...)
+}
+
+// read next pdu and return its command_id and sequence_number
+func readPdu(conn net.Conn) (uint32, uint32, error) {
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	head := make([]byte, 16)
+	if _, err := io.ReadFull(conn, head); err != nil {
+		return 0, 0, err
+	}
+	cmdLen := binary.BigEndian.Uint32(head[0:])
+	if cmdLen > 16 {
+		if _, err := io.ReadFull(conn, make([]byte, cmdLen-16)); err != nil {
+			return 0, 0, err
+		}
+	}
+	return binary.BigEndian.Uint32(head[4:]), binary.BigEndian.Uint32(head[12:]), nil
+}
+
+func readPduHeader(t *testing.T, conn net.Conn) (uint32, uint32) {
+	cmdId, seqNum, err := readPdu(conn)
+	if err != nil {
+		t.Fatalf("cannot read pdu: %v", err)
+	}
+	return cmdId, seqNum
+}
+
 func TestPduHeaderBytes(t *testing.T) {
 	expectedBytes := []byte{
 		0x00, 0x00, 0x00, 0x10,
@@ -74,3 +111,228 @@ func TestDeliverSmPduBytes(t *testing.T) {
 		t.Errorf("PDU with string body incorrectly encoded")
 	}
 }
+
+func TestQuerySmRespPduBytes(t *testing.T) {
+	expectedBytes := []byte{
+		0x00, 0x00, 0x00, 0x27, // command_length
+		0x80, 0x00, 0x00, 0x03, // command_id
+		0x00, 0x00, 0x00, 0x00, // command_status
+		0x00, 0x00, 0x00, 0x07, // sequence_number
+		0x31, 0x32, 0x33, 0x00, // message_id
+		// final_date
+		0x32, 0x31, 0x30, 0x33, 0x30, 0x31, 0x31, 0x30, 0x32, 0x30, 0x33, 0x30, 0x35, 0x32, 0x34, 0x2b, 0x00,
+		0x05, // message_state
+		0x45, // error_code
+	}
+
+	loc := time.FixedZone("UTC+6", 6*60*60)
+	msg := Message{
+		Id:        "123",
+		State:     STATE_UNDELIVERABLE,
+		ErrorCode: 69,
+		FinalDate: time.Date(2021, 3, 1, 10, 20, 30, 500000000, loc),
+	}
+	actualBytes := querySmRespPDU(7, msg)
+	if !reflect.DeepEqual(expectedBytes, actualBytes) {
+		fmt.Printf("expected: [%s]\nactual: [%s]\n\n", hex.EncodeToString(expectedBytes), hex.EncodeToString(actualBytes))
+		t.Errorf("query_sm_resp PDU incorrectly encoded")
+	}
+}
+
+func TestSubmitMultiRespPduBytes(t *testing.T) {
+	expectedBytes := []byte{
+		0x00, 0x00, 0x00, 0x1f, // command_length
+		0x80, 0x00, 0x00, 0x21, // command_id
+		0x00, 0x00, 0x00, 0x00, // command_status
+		0x00, 0x00, 0x00, 0x09, // sequence_number
+		0x31, 0x32, 0x00, // message_id
+		0x01,                                     // no_unsuccess
+		0x01, 0x01, 0x37, 0x37, 0x30, 0x30, 0x00, // dest_addr_ton, dest_addr_npi, destination_addr
+		0x00, 0x00, 0x00, 0x45, // error_status_code
+	}
+
+	unsuccess := []UnsuccessSme{{1, 1, "7700", STS_SUBMIT_FAIL}}
+	actualBytes := submitMultiRespPDU(9, "12", unsuccess)
+	if !reflect.DeepEqual(expectedBytes, actualBytes) {
+		fmt.Printf("expected: [%s]\nactual: [%s]\n\n", hex.EncodeToString(expectedBytes), hex.EncodeToString(actualBytes))
+		t.Errorf("submit_multi_resp PDU incorrectly encoded")
+	}
+}
+
+func TestDataSmPduBytes(t *testing.T) {
+	expectedBytes := []byte{
+		0x00, 0x00, 0x00, 0x31, // command_length
+		0x00, 0x00, 0x01, 0x03, // command_id
+		0x00, 0x00, 0x00, 0x00, // command_status
+		0x00, 0x00, 0x00, 0x66, // sequence_number
+		0x73, 0x6d, 0x73, 0x63, 0x73, 0x69, 0x6d, 0x00, // service_type
+		0x00, 0x00, 0x37, 0x37, 0x30, 0x31, 0x00, // source_addr_ton, source_addr_npi, source_addr
+		0x00, 0x00, 0x31, 0x30, 0x30, 0x31, 0x00, // dest_addr_ton, dest_addr_npi, destination_addr
+		0x00,                                           // esm class
+		0x00,                                           // registered_delivery
+		0x00,                                           // data_coding
+		0x04, 0x24, 0x00, 0x04, 0x54, 0x65, 0x73, 0x74, // message_payload tlv
+	}
+
+	actualBytes := dataSmPDU("7701", "1001", []byte("Test"), CODING_DEFAULT, 102, 0x00, nil)
+	if !reflect.DeepEqual(expectedBytes, actualBytes) {
+		fmt.Printf("expected: [%s]\nactual: [%s]\n\n", hex.EncodeToString(expectedBytes), hex.EncodeToString(actualBytes))
+		t.Errorf("data_sm PDU incorrectly encoded")
+	}
+}
+
+func TestUcs2SurrogatePairs(t *testing.T) {
+	expectedBytes := []byte{0x00, 0x41, 0xD8, 0x3D, 0xDE, 0x00}
+	actualBytes := toUcs2Coding("A\U0001F600")
+	if !reflect.DeepEqual(expectedBytes, actualBytes) {
+		fmt.Printf("expected: [%s]\nactual: [%s]\n\n", hex.EncodeToString(expectedBytes), hex.EncodeToString(actualBytes))
+		t.Errorf("Astral character incorrectly encoded")
+	}
+
+	// 66 characters and emoji: first part would end with high surrogate of the emoji
+	text := ""
+	for i := 0; i < 66; i++ {
+		text += "a"
+	}
+	text += "\U0001F600 and more text to make message long enough"
+	chunks := splitOctets(toUcs2Coding(text), 6, true)
+	if len(chunks) != 2 {
+		t.Fatalf("expected 2 parts, got %d", len(chunks))
+	}
+	if len(chunks[0]) != 132 {
+		t.Errorf("surrogate pair should be moved to the second part, got first part of %d bytes", len(chunks[0]))
+	}
+	joined := append(append([]byte{}, chunks[0]...), chunks[1]...)
+	if decoded := decodeShortMessage(joined, CODING_UCS2); decoded != text {
+		t.Errorf("expected [%s], got [%s]", text, decoded)
+	}
+}
+
+func TestMoSegmentation(t *testing.T) {
+	smsc := NewSmsc(false)
+	text := ""
+	for len(text) < 200 {
+		text += "a"
+	}
+
+	parts, _, err := smsc.moParts(text, MoOptions{Segmentation: SEGMENTATION_SAR}, 1)
+	if err != nil || len(parts) != 2 {
+		t.Fatalf("expected 2 SAR parts, got %d (%v)", len(parts), err)
+	}
+	for i, part := range parts {
+		seg, ok := segmentOf(&SubmitSm{EsmClass: part.EsmClass, ShortMessage: part.ShortMessage, Tlvs: part.Tlvs})
+		if !ok || seg.Total != 2 || seg.Seq != i+1 || part.EsmClass != 0 {
+			t.Errorf("unexpected SAR part %+v", part)
+		}
+	}
+
+	parts, _, err = smsc.moParts(text, MoOptions{Segmentation: SEGMENTATION_PAYLOAD}, 1)
+	if err != nil || len(parts) != 1 || parts[0].ShortMessage != nil {
+		t.Fatalf("expected single part with message_payload, got %+v (%v)", parts, err)
+	}
+	if payload, ok := findTlv(parts[0].Tlvs, TLV_MESSAGE_PAYLOAD); !ok || payload.Len != 200 {
+		t.Errorf("expected message_payload of 200 octets, got %+v", parts[0].Tlvs)
+	}
+
+	if _, _, err := smsc.moParts(text, MoOptions{Segmentation: "unknown"}, 1); err == nil {
+		t.Errorf("unknown segmentation should be rejected")
+	}
+}
+
+func TestConcatReferences(t *testing.T) {
+	smsc := NewSmsc(false)
+	text := ""
+	for len(text) < 200 {
+		text += "a"
+	}
+
+	parts, _, _ := smsc.moParts(text, MoOptions{Ref16: true}, 0x1234)
+	if len(parts) != 2 {
+		t.Fatalf("expected 2 parts, got %d", len(parts))
+	}
+	expectedUdh := []byte{0x06, IE_CONCAT_16BIT, 0x04, 0x12, 0x34, 0x02, 0x01}
+	if !reflect.DeepEqual(expectedUdh, parts[0].ShortMessage[:7]) || len(parts[0].ShortMessage) != 7+152 {
+		t.Errorf("unexpected first part %v", parts[0].ShortMessage)
+	}
+	seg, ok := segmentOf(&SubmitSm{EsmClass: parts[1].EsmClass, ShortMessage: parts[1].ShortMessage})
+	if !ok || seg.Ref != 0x1234 || seg.Seq != 2 || seg.Total != 2 {
+		t.Errorf("unexpected segment %+v", seg)
+	}
+
+	parts, _, _ = smsc.moParts(text, MoOptions{}, 0x1234)
+	if parts[0].ShortMessage[3] != 0x34 {
+		t.Errorf("8-bit reference should be low byte of the reference, got %v", parts[0].ShortMessage[:6])
+	}
+
+	refs := newConcatRefs()
+	first := refs.Next()
+	if second := refs.Next(); second != first+1 {
+		t.Errorf("expected reference %d, got %d", first+1, second)
+	}
+}
+
+func TestConcurrentSessions(t *testing.T) {
+	smsc := NewSmsc(false)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("cannot listen: %v", err)
+	}
+	defer ln.Close()
+	go smsc.Serve(ln)
+
+	const sessionCount = 50
+	var clients sync.WaitGroup
+	stop := make(chan struct{})
+
+	// read sessions from other goroutines, like web handlers do
+	go func() {
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+				smsc.BoundSystemIds()
+				smsc.BoundSessions()
+			}
+		}
+	}()
+
+	for i := 0; i < sessionCount; i++ {
+		clients.Add(1)
+		go func(i int) {
+			defer clients.Done()
+			conn, err := net.Dial("tcp", ln.Addr().String())
+			if err != nil {
+				t.Errorf("cannot connect: %v", err)
+				return
+			}
+			defer conn.Close()
+
+			systemId := fmt.Sprint("client", i)
+			conn.Write(bindTransceiverPDU(systemId))
+			if cmdId, _, err := readPdu(conn); err != nil || cmdId != BIND_TRANSCEIVER_RESP {
+				t.Errorf("expected bind_transceiver_resp, got 0x%08X (%v)", cmdId, err)
+				return
+			}
+			// MO message is written concurrently with enquire_link_resp
+			go smsc.SendMoMessage("7701", "1001", "Test", systemId, MoOptions{})
+			conn.Write(headerPDU(ENQUIRE_LINK, STS_OK, 2))
+			for received := 0; received < 2; received++ {
+				if cmdId, _, err := readPdu(conn); err != nil || (cmdId != DELIVER_SM && cmdId != ENQUIRE_LINK_RESP) {
+					t.Errorf("unexpected pdu 0x%08X (%v)", cmdId, err)
+				}
+			}
+		}(i)
+	}
+	clients.Wait()
+	close(stop)
+
+	// wait for connection handlers
+	deadline := time.Now().Add(time.Second)
+	for len(smsc.BoundSessions()) > 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if sessions := smsc.BoundSessions(); len(sessions) > 0 {
+		t.Errorf("expected all sessions to be removed, got %d", len(sessions))
+	}
+}
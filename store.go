@@ -0,0 +1,284 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+// message states
+
+const (
+	STATE_ENROUTE       = 1
+	STATE_DELIVERED     = 2
+	STATE_EXPIRED       = 3
+	STATE_DELETED       = 4
+	STATE_UNDELIVERABLE = 5
+	STATE_ACCEPTED      = 6
+	STATE_UNKNOWN       = 7
+	STATE_REJECTED      = 8
+)
+
+// max number of messages kept in the store, oldest messages are evicted first
+const MAX_STORED_MESSAGES = 100000
+
+type Message struct {
+	Key        string // unique key in the store
+	Id         string
+	CmdId      uint32 // command used to submit the message
+	SystemId   string
+	Sm         *SubmitSm
+	State      byte
+	ErrorCode  int
+	SubmitDate time.Time
+	FinalDate  time.Time
+	timer      *time.Timer // pending delivery
+	schedule   uint64      // incremented when delivery is (re)scheduled, steps of older schedules are ignored
+	seq        uint64      // order in which messages were added to the store
+	conn       net.Conn    // connection the message was submitted on, receipts are sent to it
+}
+
+// message is final once its final date is set. ACCEPTED is both the state of the pending
+// message after intermediate notification and a possible final state (ACCEPTD receipt)
+func (msg *Message) IsFinal() bool {
+	return !msg.FinalDate.IsZero()
+}
+
+// scheduled delivery time, zero if message should be delivered immediately.
+// Relative time is counted from the submit date
+func (msg *Message) ScheduleDate() time.Time {
+	t, _ := parseSmppTime(msg.Sm.ScheduleDeliveryTime, msg.SubmitDate)
+	return t
+}
+
+// time when the message expires, zero if message has no validity_period
+func (msg *Message) ExpiryDate() time.Time {
+	t, _ := parseSmppTime(msg.Sm.ValidityPeriod, msg.SubmitDate)
+	return t
+}
+
+// MessageStore keeps accepted messages and their states.
+// All methods are safe for concurrent use, messages are returned as copies
+type MessageStore struct {
+	mu       sync.Mutex
+	messages map[string]*Message
+	keys     keyRing       // in order of arrival
+	lastSeq  uint64        // sequence number of the last added message
+	added    chan struct{} // closed and replaced when message is added
+}
+
+func NewMessageStore() *MessageStore {
+	return &MessageStore{
+		messages: make(map[string]*Message),
+		keys:     keyRing{max: MAX_STORED_MESSAGES},
+		added:    make(chan struct{}),
+	}
+}
+
+// store the message and return the key under which it could be found.
+// Key is the message id, unless the store already has a message with the
+// same id (recipients of the submit_multi share one message_id).
+// Pending delivery of the evicted oldest message is cancelled
+func (store *MessageStore) Add(msg Message) string {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	if store.keys.Full() {
+		oldest := store.keys.At(0)
+		if timer := store.messages[oldest].timer; timer != nil {
+			timer.Stop()
+		}
+		delete(store.messages, oldest)
+	}
+	key := msg.Id
+	for n := 2; store.messages[key] != nil; n++ {
+		key = fmt.Sprintf("%s#%d", msg.Id, n)
+	}
+	msg.Key = key
+	store.lastSeq++
+	msg.seq = store.lastSeq
+	store.messages[key] = &msg
+	store.keys.Push(key)
+	close(store.added)
+	store.added = make(chan struct{})
+	return key
+}
+
+func (store *MessageStore) Get(key string) (Message, bool) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	msg, ok := store.messages[key]
+	if !ok {
+		return Message{}, false
+	}
+	return *msg, true
+}
+
+// copies of the messages stored under the message id in order of arrival,
+// recipients of the submit_multi share one message_id
+func (store *MessageStore) GetAll(id string) []Message {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	var list []Message
+	key := id
+	for n := 2; store.messages[key] != nil; n++ {
+		list = append(list, *store.messages[key])
+		key = fmt.Sprintf("%s#%d", id, n)
+	}
+	return list
+}
+
+// apply update function to the stored message. Returns false if message is not found
+func (store *MessageStore) Update(key string, update func(msg *Message)) bool {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	msg, ok := store.messages[key]
+	if !ok {
+		return false
+	}
+	update(msg)
+	return true
+}
+
+// apply update function to every message accepted by the match function.
+// Returns number of updated messages
+func (store *MessageStore) UpdateMatching(match func(msg *Message) bool, update func(msg *Message)) int {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	count := 0
+	for i := 0; i < store.keys.Len(); i++ {
+		msg := store.messages[store.keys.At(i)]
+		if match(msg) {
+			update(msg)
+			count++
+		}
+	}
+	return count
+}
+
+// copies of the messages accepted by the match function in order of arrival
+func (store *MessageStore) List(match func(msg *Message) bool) []Message {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	var list []Message
+	for i := 0; i < store.keys.Len(); i++ {
+		if msg := store.messages[store.keys.At(i)]; match(msg) {
+			list = append(list, *msg)
+		}
+	}
+	return list
+}
+
+// wait until message accepted by the match function is stored. Returns the first matching
+// message added before done is closed, false if there is none. Already stored messages
+// are matched only if includeStored is set
+func (store *MessageStore) Wait(match func(msg *Message) bool, includeStored bool, done <-chan struct{}) (Message, bool) {
+	store.mu.Lock()
+	var seen uint64 // messages up to this sequence number are already checked
+	if !includeStored {
+		seen = store.lastSeq
+	}
+	store.mu.Unlock()
+	for {
+		store.mu.Lock()
+		first := store.keys.Len()
+		for first > 0 && store.messages[store.keys.At(first-1)].seq > seen {
+			first--
+		}
+		for i := first; i < store.keys.Len(); i++ {
+			if msg := store.messages[store.keys.At(i)]; match(msg) {
+				found := *msg
+				store.mu.Unlock()
+				return found, true
+			}
+		}
+		seen = store.lastSeq
+		added := store.added
+		store.mu.Unlock()
+
+		select {
+		case <-added:
+		case <-done:
+			return Message{}, false
+		}
+	}
+}
+
+// remove all messages, receipts of pending messages are not sent. Returns number of removed messages
+func (store *MessageStore) Clear() int {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	for _, msg := range store.messages {
+		if msg.timer != nil {
+			msg.timer.Stop()
+		}
+	}
+	count := store.keys.Len()
+	store.messages = make(map[string]*Message)
+	store.keys.Reset()
+	return count
+}
+
+// keyRing keeps up to max keys in order of arrival, the oldest key is overwritten when the ring is full
+type keyRing struct {
+	keys  []string
+	start int // position of the oldest key
+	max   int
+}
+
+func (r *keyRing) Full() bool {
+	return len(r.keys) >= r.max
+}
+
+func (r *keyRing) Push(key string) {
+	if !r.Full() {
+		r.keys = append(r.keys, key)
+		return
+	}
+	r.keys[r.start] = key
+	r.start = (r.start + 1) % len(r.keys)
+}
+
+func (r *keyRing) Len() int {
+	return len(r.keys)
+}
+
+// i-th key in order of arrival
+func (r *keyRing) At(i int) string {
+	return r.keys[(r.start+i)%len(r.keys)]
+}
+
+func (r *keyRing) Reset() {
+	r.keys = nil
+	r.start = 0
+}
+
+func stateName(state byte) string {
+	switch state {
+	case STATE_ENROUTE:
+		return "ENROUTE"
+	case STATE_DELIVERED:
+		return "DELIVERED"
+	case STATE_EXPIRED:
+		return "EXPIRED"
+	case STATE_DELETED:
+		return "DELETED"
+	case STATE_UNDELIVERABLE:
+		return "UNDELIVERABLE"
+	case STATE_ACCEPTED:
+		return "ACCEPTED"
+	case STATE_UNKNOWN:
+		return "UNKNOWN"
+	case STATE_REJECTED:
+		return "REJECTED"
+	default:
+		return "INVALID"
+	}
+}
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestMessageStoreEviction(t *testing.T) {
+	store := NewMessageStore()
+	store.keys.max = 3
+	timer := time.AfterFunc(time.Hour, func() {})
+	store.Add(Message{Id: "1", timer: timer})
+	for i := 2; i <= 5; i++ {
+		store.Add(Message{Id: fmt.Sprint(i)})
+	}
+	if timer.Stop() {
+		t.Errorf("pending delivery of the evicted message should be stopped")
+	}
+	for i := 1; i <= 5; i++ {
+		if _, ok := store.Get(fmt.Sprint(i)); ok != (i > 2) {
+			t.Errorf("message %d: expected stored %v, got %v", i, i > 2, ok)
+		}
+	}
+	if store.keys.Len() != 3 || store.keys.At(0) != "3" || store.keys.At(2) != "5" {
+		t.Errorf("keys should be kept in order of arrival, got %v", store.keys.keys)
+	}
+}
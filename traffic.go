@@ -0,0 +1,145 @@
+package main
+
+import (
+	"encoding/binary"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// kinds of the traffic log entries
+const (
+	TRAFFIC_SUBMIT = "submit" // submit_sm, submit_multi and data_sm received from ESME
+	TRAFFIC_DLR    = "dlr"    // delivery receipts, intermediate notifications and SME acknowledgements
+	TRAFFIC_MO     = "mo"     // parts of MO messages
+)
+
+// max number of entries kept in the traffic log, oldest entries are evicted first
+const MAX_TRAFFIC_ENTRIES = 1000
+
+// TrafficEntry is a message PDU received from or sent to ESME
+type TrafficEntry struct {
+	Id              int
+	Date            time.Time
+	Kind            string
+	SystemId        string
+	SourceAddr      string
+	DestinationAddr string
+	MessageId       string // empty for MO messages
+	Text            string
+	Pdu             []byte
+}
+
+func (entry TrafficEntry) Command() string {
+	if len(entry.Pdu) < 8 {
+		return ""
+	}
+	return commandName(binary.BigEndian.Uint32(entry.Pdu[4:]))
+}
+
+// TrafficLog keeps latest message PDUs. All methods are safe for concurrent use
+type TrafficLog struct {
+	mu      sync.Mutex
+	entries []TrafficEntry // in order of arrival
+	lastId  int
+}
+
+func NewTrafficLog() *TrafficLog {
+	return &TrafficLog{}
+}
+
+// add entry to the log, id and date of the entry are assigned by the log
+func (l *TrafficLog) Add(entry TrafficEntry) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if len(l.entries) >= MAX_TRAFFIC_ENTRIES {
+		l.entries = l.entries[1:]
+	}
+	l.lastId++
+	entry.Id = l.lastId
+	entry.Date = time.Now()
+	l.entries = append(l.entries, entry)
+}
+
+// latest entries accepted by the match function, newest first
+func (l *TrafficLog) Latest(limit int, match func(entry *TrafficEntry) bool) []TrafficEntry {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var list []TrafficEntry
+	for i := len(l.entries) - 1; i >= 0 && len(list) < limit; i-- {
+		if match(&l.entries[i]) {
+			list = append(list, l.entries[i])
+		}
+	}
+	return list
+}
+
+func (l *TrafficLog) Get(id int) (TrafficEntry, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for _, entry := range l.entries {
+		if entry.Id == id {
+			return entry, true
+		}
+	}
+	return TrafficEntry{}, false
+}
+
+// add entry for the message PDU received from ESME
+func (smsc *Smsc) logSubmit(systemId, msgId string, sm *SubmitSm, dests []string, pdu []byte) {
+	destinationAddr := sm.DestinationAddr
+	if dests != nil {
+		destinationAddr = strings.Join(dests, ", ")
+	}
+	smsc.Traffic.Add(TrafficEntry{
+		Kind:            TRAFFIC_SUBMIT,
+		SystemId:        systemId,
+		SourceAddr:      sm.SourceAddr,
+		DestinationAddr: destinationAddr,
+		MessageId:       msgId,
+		Text:            smsc.smText(sm),
+		Pdu:             pdu,
+	})
+}
+
+// SessionStats counts PDUs of the connection, counters are updated atomically
+type SessionStats struct {
+	PdusIn   uint64
+	PdusOut  uint64
+	Submits  uint64 // submit_sm, submit_multi and data_sm received
+	Delivers uint64 // deliver_sm and data_sm sent (MO messages and receipts)
+}
+
+func (stats *SessionStats) countIn(cmdId uint32) {
+	atomic.AddUint64(&stats.PdusIn, 1)
+	if cmdId == SUBMIT_SM || cmdId == SUBMIT_MULTI || cmdId == DATA_SM {
+		atomic.AddUint64(&stats.Submits, 1)
+	}
+}
+
+func (stats *SessionStats) countOut(pdu []byte) {
+	atomic.AddUint64(&stats.PdusOut, 1)
+	if len(pdu) < 8 {
+		return
+	}
+	if cmdId := binary.BigEndian.Uint32(pdu[4:]); cmdId == DELIVER_SM || cmdId == DATA_SM {
+		atomic.AddUint64(&stats.Delivers, 1)
+	}
+}
+
+// copy of the counters, zero counters if stats is nil
+func (stats *SessionStats) Snapshot() SessionStats {
+	if stats == nil {
+		return SessionStats{}
+	}
+	return SessionStats{
+		PdusIn:   atomic.LoadUint64(&stats.PdusIn),
+		PdusOut:  atomic.LoadUint64(&stats.PdusOut),
+		Submits:  atomic.LoadUint64(&stats.Submits),
+		Delivers: atomic.LoadUint64(&stats.Delivers),
+	}
+}
@@ -5,14 +5,19 @@ import (
 	"log"
 	"net/http"
 	"net/url"
+	"strconv"
+	"strings"
 	"sync"
-	"text/template"
+	"time"
 )
 
-const WEB_PAGE_TPL = `
+// layout of the web pages. Header argument is the page refresh interval in seconds, nil for static pages
+const WEB_LAYOUT_TPL = `
+{{ define "header" }}
 <html lang="en">
 <head>
   <meta charset="utf-8">
+  {{ if . }}<meta http-equiv="refresh" content="{{ . }}">{{ end }}
   <title>smscsim web page</title>
   <style>
     html, body {
@@ -22,8 +27,21 @@ const WEB_PAGE_TPL = `
       font-family: sans-serif;
       background: #f0f0f0;
     }
+    #nav {
+      margin: 20px auto 0 auto;
+      width: 640px;
+      font-size: 16px;
+      text-align: center;
+    }
+    #nav a {
+      color: #3585f7;
+      margin: 0 10px;
+      text-decoration: none;
+      font-weight: bold;
+      text-transform: uppercase;
+    }
     #container {
-      margin: 40px auto;
+      margin: 20px auto 40px auto;
       width: 560px;
       padding: 10px 40px;
       border-radius: 6px;
@@ -66,6 +84,11 @@ const WEB_PAGE_TPL = `
     textarea {
       resize: vertical;
     }
+    input[type="checkbox"] {
+      display: inline;
+      width: auto;
+      margin: 0 5px 0 0;
+    }
     select {
       min-width: 200px;
     }
@@ -85,9 +108,84 @@ const WEB_PAGE_TPL = `
     .error {
       color: #f44336;
     }
+    #pending {
+      margin: 20px auto;
+      padding: 10px;
+      width: 400px;
+    }
+    form.session {
+      margin: 0 0 10px 0;
+      padding: 0;
+      width: auto;
+      font-size: 16px;
+    }
+    form.session span {
+      display: block;
+      margin: 0 0 5px 0;
+    }
+    #concat {
+      margin: 20px auto;
+      padding: 10px;
+      width: 400px;
+      font-size: 16px;
+    }
+    #concat span {
+      display: block;
+    }
+    #concat pre {
+      white-space: pre-wrap;
+      background: #f0f0f0;
+      padding: 5px;
+      margin: 5px 0 15px 0;
+    }
+    #container.wide {
+      width: 1100px;
+    }
+    table {
+      width: 100%;
+      border-collapse: collapse;
+      font-size: 14px;
+      margin: 10px 0 20px 0;
+    }
+    th, td {
+      text-align: left;
+      vertical-align: top;
+      padding: 4px 6px;
+      border-bottom: 1px solid #dfdfdf;
+    }
+    th {
+      color: #657c89;
+      text-transform: uppercase;
+    }
+    td form.session {
+      margin: 0;
+    }
+    td a, p a {
+      color: #3585f7;
+    }
+    pre.hex {
+      font-size: 13px;
+      background: #f0f0f0;
+      padding: 5px;
+      overflow-x: auto;
+    }
   </style>
 </head>
 <body>
+<div id="nav">
+  <a href="/">Send MO</a>
+  <a href="/sessions">Sessions</a>
+  <a href="/log">Message log</a>
+</div>
+{{ end }}
+{{ define "footer" }}
+</body>
+</html>
+{{ end }}
+`
+
+const WEB_PAGE_TPL = `
+{{ template "header" }}
 <div id="container">
 <form action="/" method="POST">
   <p id="title">Send MO message</p>
@@ -114,6 +212,38 @@ const WEB_PAGE_TPL = `
     <label for="short_message">Short message</label>
     <textarea id="short_message" name="message" placeholder="Short message..."></textarea>
   </p>
+  <p>
+    <label for="coding">Data coding</label>
+    <select id="coding" name="coding">
+      <option value="">auto (GSM7 or UCS2)</option>
+      <option value="gsm7">0x00 GSM7</option>
+      <option value="ia5">0x01 IA5</option>
+      <option value="octet">0x02 8-bit binary (hex)</option>
+      <option value="latin1">0x03 Latin-1</option>
+      <option value="binary">0x04 8-bit binary (hex)</option>
+      <option value="cyrillic">0x06 Cyrillic</option>
+      <option value="ucs2">0x08 UCS2</option>
+      <option value="flash">0xF0 GSM7 flash</option>
+    </select>
+  </p>
+  <p>
+    <label for="segmentation">Long message segmentation</label>
+    <select id="segmentation" name="segmentation">
+      <option value="udh">UDH</option>
+      <option value="sar">SAR TLVs</option>
+      <option value="payload">message_payload TLV</option>
+    </select>
+  </p>
+  <p>
+    <label for="ref16"><input id="ref16" type="checkbox" name="ref16" value="1"> 16-bit concatenation reference</label>
+  </p>
+  <p>
+    <label for="ref">Concatenation reference</label>
+    <input id="ref" type="text" name="ref" placeholder="next reference of the session">
+  </p>
+  <p>
+    <label for="data_sm"><input id="data_sm" type="checkbox" name="data_sm" value="1"> Send as data_sm</label>
+  </p>
   <p>
     <input type="submit" value="Submit" {{ if not .SystemIds }} disabled {{ end }}>
   </p>
@@ -124,31 +254,82 @@ const WEB_PAGE_TPL = `
   <p class="error">{{ .ErrorMessage }}</p>
   {{ end }}
 </form>
+<div id="pending">
+  <p id="title">Pending messages</p>
+  {{ if not .Pending }}
+  <p><sub>No pending messages</sub></p>
+  {{ end }}
+  {{ range $msg := .Pending }}
+  <form class="session" action="/dlr" method="POST">
+    <span>[<a href="/messages/{{ $msg.Id }}">{{ $msg.Id }}</a>] {{ $msg.SystemId }}: {{ $msg.Sm.SourceAddr }} &rarr; {{ $msg.Sm.DestinationAddr }}</span>
+    <input type="hidden" name="key" value="{{ $msg.Key }}">
+    <select name="stat">
+      <option value="DELIVRD">DELIVRD</option>
+      <option value="UNDELIV">UNDELIV</option>
+      <option value="EXPIRED">EXPIRED</option>
+      <option value="REJECTD">REJECTD</option>
+      <option value="ACCEPTD">ACCEPTD</option>
+      <option value="DELETED">DELETED</option>
+      <option value="UNKNOWN">UNKNOWN</option>
+    </select>
+    <input type="text" name="err" placeholder="err" size="3">
+    <input type="text" name="submit_date" placeholder="submit date YYYY-MM-DD hh:mm:ss" size="28">
+    <input type="text" name="done_date" placeholder="done date YYYY-MM-DD hh:mm:ss" size="28">
+    <button type="submit">Send receipt</button>
+  </form>
+  {{ end }}
 </div>
-</body>
-</html>
+<div id="concat">
+  <p id="title">Concatenated messages</p>
+  {{ if not .Concat }}
+  <p><sub>No concatenated messages received</sub></p>
+  {{ end }}
+  {{ range $msg := .Concat }}
+  <span>{{ $msg.SystemId }}: {{ $msg.SourceAddr }} &rarr; {{ $msg.DestinationAddr }}, ref {{ $msg.Ref }}, {{ len $msg.Parts }}/{{ $msg.Total }} segments</span>
+  {{ if $msg.Missing }}<span class="error">missing segments {{ $msg.Missing }}</span>{{ end }}
+  {{ if $msg.Duplicates }}<span class="error">duplicate segments {{ $msg.Duplicates }}</span>{{ end }}
+  {{ if $msg.Invalid }}<span class="error">invalid segments {{ $msg.Invalid }}</span>{{ end }}
+  <pre>{{ $msg.Text }}</pre>
+  {{ end }}
+</div>
+</div>
+{{ template "footer" }}
 `
 
 type WebServer struct {
-	Smsc Smsc
+	Smsc *Smsc
 }
 
 type TplVars struct {
 	SystemIds    []string
+	Concat       []ConcatMessage
+	Pending      []Message
 	Message      string
 	ErrorMessage string
 	Sender       string
 	Recipient    string
 }
 
-func NewWebServer(smsc Smsc) WebServer {
+func NewWebServer(smsc *Smsc) WebServer {
 	return WebServer{smsc}
 }
 
 func (webServer *WebServer) Start(port int, wg *sync.WaitGroup) {
 	defer wg.Done()
 
-	http.HandleFunc("/", webHandler(&webServer.Smsc))
+	http.HandleFunc("/", webHandler(webServer.Smsc))
+	http.HandleFunc("/sessions", sessionsWebHandler(webServer.Smsc))
+	http.HandleFunc("/dlr", dlrWebHandler(webServer.Smsc))
+	http.HandleFunc("/log", logWebHandler(webServer.Smsc))
+	http.HandleFunc("/log/", logEntryWebHandler(webServer.Smsc))
+	http.HandleFunc("/messages/", messageWebHandler(webServer.Smsc))
+	http.HandleFunc("/api/v1/mo", moApiHandler(webServer.Smsc))
+	http.HandleFunc("/api/v1/sessions", sessionsListApiHandler(webServer.Smsc))
+	http.HandleFunc("/api/v1/sessions/", sessionsApiHandler(webServer.Smsc))
+	http.HandleFunc("/api/v1/messages", messagesApiHandler(webServer.Smsc))
+	http.HandleFunc("/api/v1/messages/", messageApiHandler(webServer.Smsc))
+	http.HandleFunc("/api/v1/messages/wait", waitApiHandler(webServer.Smsc))
+	http.HandleFunc("/api/v1/concat", concatApiHandler(webServer.Smsc))
 	log.Println("Starting web server on port", port)
 	log.Fatal(http.ListenAndServe(fmt.Sprint(":", port), nil))
 }
@@ -157,6 +338,7 @@ func webHandler(smsc *Smsc) func(http.ResponseWriter, *http.Request) {
 	if smsc == nil {
 		log.Fatal("nil Smsc provided to web handler")
 	}
+	tpl := pageTemplate("webpage", WEB_PAGE_TPL)
 	return func(w http.ResponseWriter, r *http.Request) {
 		if r.Method == "POST" {
 			if err := r.ParseForm(); err != nil {
@@ -169,8 +351,11 @@ func webHandler(smsc *Smsc) func(http.ResponseWriter, *http.Request) {
 				recipient := params.Get("recipient")
 				message := params.Get("message")
 				systemId := params.Get("system_id")
+				opts, err := moOptions(params)
 				// send MO
-				err := smsc.SendMoMessage(sender, recipient, message, systemId)
+				if err == nil {
+					_, err = smsc.SendMoMessage(sender, recipient, message, systemId, opts)
+				}
 				q := url.Values{}
 				if err != nil {
 					q.Add("error", err.Error())
@@ -183,18 +368,141 @@ func webHandler(smsc *Smsc) func(http.ResponseWriter, *http.Request) {
 				http.Redirect(w, r, redirUrl, http.StatusSeeOther)
 			}
 		} else {
-			tpl, err := template.New("webpage").Parse(WEB_PAGE_TPL)
-			if err != nil {
-				log.Fatal("Cannot parse template of the web page")
-			}
 			q := r.URL.Query()
 			errorMsg := q.Get("error")
 			msg := q.Get("message")
 			sender := q.Get("sender")
 			recipient := q.Get("recipient")
 			systemIds := smsc.BoundSystemIds()
-			tplVars := TplVars{systemIds, msg, errorMsg, sender, recipient}
+			concat := smsc.Concat.List()
+			if len(concat) > 20 {
+				concat = concat[len(concat)-20:] // only latest messages
+			}
+			pending := smsc.Store.List(func(m *Message) bool { return !m.IsFinal() })
+			if len(pending) > 20 {
+				pending = pending[len(pending)-20:]
+			}
+			tplVars := TplVars{systemIds, concat, pending, msg, errorMsg, sender, recipient}
 			tpl.Execute(w, tplVars)
 		}
 	}
 }
+
+// sessions page and its session control buttons
+func sessionsWebHandler(smsc *Smsc) func(http.ResponseWriter, *http.Request) {
+	tpl := pageTemplate("sessions", SESSIONS_PAGE_TPL)
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			q := r.URL.Query()
+			tpl.Execute(w, SessionsTplVars{smsc.BoundSessions(), q.Get("message"), q.Get("error")})
+			return
+		}
+		if err := r.ParseForm(); err != nil {
+			log.Printf("Cannot parse POST params due [%v]", err)
+			fmt.Fprintf(w, "Error. Cannot parse POST params")
+			return
+		}
+		q := url.Values{}
+		sessionId, err := strconv.Atoi(r.Form.Get("session_id"))
+		if err != nil {
+			q.Add("error", "Invalid session id")
+		} else {
+			switch action := r.Form.Get("action"); action {
+			case "unbind":
+				err = smsc.UnbindSession(sessionId, false)
+			case "unbind_wait":
+				err = smsc.UnbindSession(sessionId, true)
+			case "close":
+				err = smsc.CloseSession(sessionId)
+			default:
+				err = fmt.Errorf("Unknown action [%s]", action)
+			}
+			if err != nil {
+				q.Add("error", err.Error())
+			} else {
+				q.Add("message", fmt.Sprintf("Session #%d: %s done", sessionId, r.Form.Get("action")))
+			}
+		}
+		http.Redirect(w, r, "/sessions?"+q.Encode(), http.StatusSeeOther)
+	}
+}
+
+// handle manual delivery receipt forms of the web page
+func dlrWebHandler(smsc *Smsc) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			http.Redirect(w, r, "/", http.StatusSeeOther)
+			return
+		}
+		if err := r.ParseForm(); err != nil {
+			log.Printf("Cannot parse POST params due [%v]", err)
+			fmt.Fprintf(w, "Error. Cannot parse POST params")
+			return
+		}
+		q := url.Values{}
+		dlr, err := manualDlr(r.Form)
+		var msg Message
+		var sent bool
+		if err == nil {
+			msg, sent, err = smsc.DeliverManually(r.Form.Get("key"), dlr)
+		}
+		if err != nil {
+			q.Add("error", err.Error())
+		} else if !sent {
+			q.Add("message", fmt.Sprintf("Message [%s] is %s, delivery receipt was not requested", msg.Id, stateName(msg.State)))
+		} else {
+			q.Add("message", fmt.Sprintf("Message [%s] is %s, delivery receipt was sent", msg.Id, stateName(msg.State)))
+		}
+		http.Redirect(w, r, "/?"+q.Encode(), http.StatusSeeOther)
+	}
+}
+
+// layout of the dates in the manual delivery receipt form
+const FORM_DATE_LAYOUT = "2006-01-02 15:04:05"
+
+// manual delivery receipt from the form params stat, err, submit_date and done_date
+func manualDlr(params url.Values) (ManualDlr, error) {
+	var dlr ManualDlr
+	state, ok := finalStateByStat(params.Get("stat"))
+	if !ok {
+		return dlr, fmt.Errorf("Invalid stat [%s]", params.Get("stat"))
+	}
+	dlr.State = state
+	if v := strings.TrimSpace(params.Get("err")); v != "" {
+		errCode, err := strconv.Atoi(v)
+		if err != nil || errCode < 0 || errCode > 999 {
+			return dlr, fmt.Errorf("Invalid error code [%s]", v)
+		}
+		dlr.Err = errCode
+	}
+	dates := []*time.Time{&dlr.SubmitDate, &dlr.DoneDate}
+	for i, name := range []string{"submit_date", "done_date"} {
+		if v := strings.TrimSpace(params.Get(name)); v != "" {
+			t, err := time.ParseInLocation(FORM_DATE_LAYOUT, v, time.Local)
+			if err != nil {
+				return dlr, fmt.Errorf("Invalid %s [%s], YYYY-MM-DD hh:mm:ss expected", name, v)
+			}
+			*dates[i] = t
+		}
+	}
+	return dlr, nil
+}
+
+// MO options from the form params. Concatenation reference is forced if ref param is not empty
+func moOptions(params url.Values) (MoOptions, error) {
+	opts := MoOptions{
+		DataSm:       params.Get("data_sm") != "",
+		Coding:       params.Get("coding"),
+		Segmentation: params.Get("segmentation"),
+		Ref16:        params.Get("ref16") != "",
+	}
+	if ref := strings.TrimSpace(params.Get("ref")); ref != "" {
+		v, err := strconv.ParseUint(ref, 0, 16)
+		if err != nil {
+			return opts, fmt.Errorf("Invalid concatenation reference [%s]", ref)
+		}
+		opts.ForceRef = true
+		opts.Ref = uint16(v)
+	}
+	return opts, nil
+}